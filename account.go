@@ -14,42 +14,55 @@
 package s3
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"strings"
 	"sync"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // StoreAccount stores an account.  It will fail if it cannot store the data.
 // Note this will overwrite an existing account with the same ID.  It will not, however, allow multiple accounts with the same
-// name to co-exist in the same wallet.
+// name to co-exist in the same wallet, returning a *DuplicateNameError if another account has the name.
 func (s *Store) StoreAccount(walletID uuid.UUID, accountID uuid.UUID, data []byte) error {
+	ctx, span := s.startSpan(context.Background(), "StoreAccount",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("account_id", accountID.String()),
+	)
+	err := s.storeAccount(ctx, walletID, accountID, data)
+	endSpan(span, err)
+
+	return err
+}
+
+func (s *Store) storeAccount(ctx context.Context, walletID uuid.UUID, accountID uuid.UUID, data []byte) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
 	// Ensure the wallet exists
-	_, err := s.RetrieveWalletByID(walletID)
+	_, err := s.retrieveWalletByID(ctx, walletID)
 	if err != nil {
 		return errors.New("unknown wallet")
 	}
 
-	// See if an account with this name already exists
-	existingAccount, err := s.RetrieveAccount(walletID, accountID)
-	if err == nil {
-		// It does; they need to have the same ID for us to overwrite it
-		info := &struct {
-			ID string `json:"uuid"`
-		}{}
-		err := json.Unmarshal(existingAccount, info)
-		if err != nil {
+	pubKeys, err := accountPubKeys(data)
+	if err != nil {
+		return err
+	}
+
+	// Ensure no other account has this name.
+	_, name, err := itemName(data)
+	if err != nil {
+		return err
+	}
+	claimed := false
+	if name != "" {
+		if claimed, err = s.claimAccountName(ctx, walletID, accountID, name); err != nil {
 			return err
 		}
-		if info.ID != accountID.String() {
-			return errors.New("account already exists")
-		}
 	}
 
 	data, err = s.encryptIfRequired(data)
@@ -58,32 +71,40 @@ func (s *Store) StoreAccount(walletID uuid.UUID, accountID uuid.UUID, data []byt
 	}
 
 	path := s.accountPath(walletID, accountID)
-	uploader := s3manager.NewUploader(s.session)
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(path),
-		Body:   bytes.NewReader(data),
-	})
-	if err != nil {
+	if err := s.upload(ctx, path, data, s.withObjectAttributes(ObjectKindAccount), s.withObjectLock()); err != nil {
+		if claimed {
+			s.releaseName(ctx, s.accountNamePath(walletID, name))
+		}
+
 		return errors.Wrap(err, "failed to store key")
 	}
 
+	if err := s.indexPubKeys(ctx, walletID, accountID, pubKeys); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 // RetrieveAccount retrieves account-level data.  It will fail if it cannot retrieve the data.
 func (s *Store) RetrieveAccount(walletID uuid.UUID, accountID uuid.UUID) ([]byte, error) {
+	ctx, span := s.startSpan(context.Background(), "RetrieveAccount",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("account_id", accountID.String()),
+	)
+	data, err := s.retrieveAccount(ctx, walletID, accountID)
+	endSpan(span, err)
+
+	return data, err
+}
+
+func (s *Store) retrieveAccount(ctx context.Context, walletID uuid.UUID, accountID uuid.UUID) ([]byte, error) {
 	path := s.accountPath(walletID, accountID)
-	buf := aws.NewWriteAtBuffer([]byte{})
-	downloader := s3manager.NewDownloader(s.session)
-	if _, err := downloader.Download(buf,
-		&s3.GetObjectInput{
-			Bucket: aws.String(s.bucket),
-			Key:    aws.String(path),
-		}); err != nil {
+	data, err := s.download(ctx, path)
+	if err != nil {
 		return nil, err
 	}
-	data, err := s.decryptIfRequired(buf.Bytes())
+	data, err = s.decrypt(ctx, path, data)
 	if err != nil {
 		return nil, err
 	}
@@ -93,36 +114,34 @@ func (s *Store) RetrieveAccount(walletID uuid.UUID, accountID uuid.UUID) ([]byte
 
 // RetrieveAccounts retrieves all account-level data for a wallet.
 func (s *Store) RetrieveAccounts(walletID uuid.UUID) <-chan []byte {
+	ctx, span := s.startSpan(context.Background(), "RetrieveAccounts",
+		attribute.String("wallet_id", walletID.String()),
+	)
+	ch := make(chan []byte, elementCapacity)
+	go func() {
+		for data := range s.retrieveAccounts(ctx, walletID) {
+			ch <- data
+		}
+		span.End()
+		close(ch)
+	}()
+
+	return ch
+}
+
+func (s *Store) retrieveAccounts(ctx context.Context, walletID uuid.UUID) <-chan []byte {
 	path := s.walletPath(walletID)
 	ch := make(chan []byte, elementCapacity)
 	go func() {
-		conn := s3.New(s.session)
-
-		contents := make([]*s3.Object, 0, elementCapacity)
-		var continuationToken *string
-		for finished := false; !finished; {
-			resp, err := conn.ListObjectsV2(&s3.ListObjectsV2Input{
-				Bucket:            aws.String(s.bucket),
-				Prefix:            aws.String(path + "/"),
-				ContinuationToken: continuationToken,
-			})
-			if err != nil {
-				close(ch)
-				return
-			}
-			contents = append(contents, resp.Contents...)
-			if resp.IsTruncated != nil && (*resp.IsTruncated) {
-				continuationToken = resp.NextContinuationToken
-			} else {
-				finished = true
-			}
+		contents, err := s.listObjects(ctx, path+"/")
+		if err != nil {
+			s.log.Error().Err(err).Msg("Failed to list objects")
+			close(ch)
+			return
 		}
 
-		// Download items concurrently (up to concurrency limit).
+		// Download items concurrently.
 		wg := sync.WaitGroup{}
-		downloader := s3manager.NewDownloader(s.session, func(d *s3manager.Downloader) {
-			d.Concurrency = downloadConcurrency
-		})
 		for _, content := range contents {
 			switch {
 			case strings.HasSuffix(*content.Key, "/"):
@@ -139,23 +158,20 @@ func (s *Store) RetrieveAccounts(walletID uuid.UUID) <-chan []byte {
 				continue
 			default:
 				wg.Add(1)
-				go func(content *s3.Object) {
+				go func(key string) {
 					defer wg.Done()
-					buf := aws.NewWriteAtBuffer(make([]byte, 0, itemCapacity))
-					_, err := downloader.Download(buf,
-						&s3.GetObjectInput{
-							Bucket: aws.String(s.bucket),
-							Key:    aws.String(*content.Key),
-						})
+					data, err := s.downloadLimited(ctx, key)
 					if err != nil {
+						s.log.Warn().Str("key", key).Str("code", errorCode(err)).Err(err).Msg("Failed to download object; skipping")
 						return
 					}
-					data, err := s.decryptIfRequired(buf.Bytes())
+					data, err = s.decrypt(ctx, key, data)
 					if err != nil {
+						s.log.Warn().Str("key", key).Err(err).Msg("Failed to decrypt object; skipping")
 						return
 					}
 					ch <- data
-				}(content)
+				}(*content.Key)
 			}
 		}
 		wg.Wait()
@@ -149,6 +149,7 @@ func TestStoreAccount(t *testing.T) {
 				s3.WithID([]byte(fmt.Sprintf("%d", rand.Int31()))),
 				s3.WithCredentialsID(os.Getenv("S3_CREDENTIALS_ID")),
 				s3.WithCredentialsSecret(os.Getenv("S3_CREDENTIALS_SECRET")),
+				s3.WithCreateDerivedBucket(true),
 			},
 		},
 		{
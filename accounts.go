@@ -0,0 +1,305 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	indexer "github.com/wealdtech/go-indexer"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// StoreAccountsError is returned by StoreAccounts when some accounts could not be stored, or were stored but could
+// not be added to the public key index.
+type StoreAccountsError struct {
+	// Stored is the number of accounts that were stored, including those in Unindexed.
+	Stored int
+	// Failed holds the error storing each account that could not be stored.
+	Failed map[uuid.UUID]error
+	// Unindexed holds the error adding each account that was stored to the public key index.  These accounts are
+	// in the wallet's account index, and can be added to the public key index with RebuildPubKeyIndex().
+	Unindexed map[uuid.UUID]error
+}
+
+func (e *StoreAccountsError) Error() string {
+	if len(e.Failed) == 0 {
+		return fmt.Sprintf("failed to index public keys of %d of %d accounts", len(e.Unindexed), e.Stored)
+	}
+
+	return fmt.Sprintf("failed to store %d of %d accounts", len(e.Failed), len(e.Failed)+e.Stored)
+}
+
+// AccountResult is the result of retrieving a single account as part of a bulk retrieval.
+type AccountResult struct {
+	// AccountID is the ID of the account.
+	AccountID uuid.UUID
+	// Data is the account-level data, or nil if the account could not be retrieved.
+	Data []byte
+	// Err is the error retrieving the account, or nil if it was retrieved.
+	Err error
+}
+
+// RetrieveAccountsByIDs retrieves account-level data for the given accounts in a wallet.
+// Accounts are retrieved concurrently, subject to the store's maximum concurrency.  A result is returned for each
+// account in the order supplied, holding either the account's data or the error retrieving it.
+func (s *Store) RetrieveAccountsByIDs(walletID uuid.UUID, accountIDs []uuid.UUID) []*AccountResult {
+	ctx, span := s.startSpan(context.Background(), "RetrieveAccountsByIDs",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.Int("accounts", len(accountIDs)),
+	)
+	results := s.retrieveAccountsByIDs(ctx, walletID, accountIDs)
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	span.SetAttributes(attribute.Int("failed", failed))
+	span.End()
+
+	return results
+}
+
+func (s *Store) retrieveAccountsByIDs(ctx context.Context, walletID uuid.UUID, accountIDs []uuid.UUID) []*AccountResult {
+	results := make([]*AccountResult, len(accountIDs))
+
+	// Download items concurrently.
+	wg := sync.WaitGroup{}
+	for i, accountID := range accountIDs {
+		wg.Add(1)
+		go func(i int, accountID uuid.UUID) {
+			defer wg.Done()
+			result := &AccountResult{
+				AccountID: accountID,
+			}
+			results[i] = result
+
+			key := s.accountPath(walletID, accountID)
+			data, err := s.downloadLimited(ctx, key)
+			if err != nil {
+				result.Err = err
+				return
+			}
+			result.Data, result.Err = s.decrypt(ctx, key, data)
+		}(i, accountID)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// StoreAccounts stores multiple accounts in a wallet, and adds them to the wallet's account index.
+// The wallet is checked once and accounts are uploaded concurrently, subject to the store's maximum concurrency,
+// after which the index is written once.  As with StoreAccount() existing accounts with the same IDs are
+// overwritten, but accounts with the same names as other accounts in the index are refused.
+// If some accounts cannot be stored the remainder are stored and added to the index, and a *StoreAccountsError
+// is returned giving the error for each account that was not stored, and for each account that was stored but could
+// not be added to the public key index.
+func (s *Store) StoreAccounts(walletID uuid.UUID, accounts map[uuid.UUID][]byte) error {
+	ctx, span := s.startSpan(context.Background(), "StoreAccounts",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.Int("accounts", len(accounts)),
+	)
+	err := s.storeAccounts(ctx, walletID, accounts)
+	endSpan(span, err)
+
+	return err
+}
+
+func (s *Store) storeAccounts(ctx context.Context, walletID uuid.UUID, accounts map[uuid.UUID][]byte) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	// Ensure the wallet exists.
+	if _, err := s.retrieveWalletByID(ctx, walletID); err != nil {
+		return errors.New("unknown wallet")
+	}
+
+	index, err := s.walletIndex(ctx, walletID)
+	if err != nil {
+		return err
+	}
+
+	// Check all accounts before any are stored.
+	failed := make(map[uuid.UUID]error)
+	seen := make(map[string]uuid.UUID, len(accounts))
+	pending := make([]*bulkAccount, 0, len(accounts))
+	for accountID, data := range accounts {
+		name, err := checkBulkAccount(index, accountID, data)
+		if err != nil {
+			failed[accountID] = err
+			continue
+		}
+		if existingID, exists := seen[name]; exists {
+			failed[accountID] = &DuplicateNameError{Kind: ObjectKindAccount, Name: name, ExistingID: existingID}
+			continue
+		}
+		seen[name] = accountID
+		pending = append(pending, &bulkAccount{id: accountID, name: name, data: data})
+	}
+
+	// Store accounts concurrently.  Each account is encrypted and requires several requests, so the number of
+	// workers is bounded by the store's maximum concurrency.
+	names := make(map[uuid.UUID]string, len(pending))
+	unindexed := make(map[uuid.UUID]error)
+	mu := sync.Mutex{}
+	work := make(chan *bulkAccount)
+	wg := sync.WaitGroup{}
+	for i := 0; i < s.maxConcurrency() && i < len(pending); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for account := range work {
+				pubKeys, err := s.storeBulkAccount(ctx, walletID, account.id, account.name, account.data)
+				// A stored account is added to the account index even if its public keys cannot be indexed.
+				var indexErr error
+				if err == nil {
+					indexErr = s.indexPubKeys(ctx, walletID, account.id, pubKeys)
+				}
+				mu.Lock()
+				switch {
+				case err != nil:
+					failed[account.id] = err
+				case indexErr != nil:
+					names[account.id] = account.name
+					unindexed[account.id] = indexErr
+				default:
+					names[account.id] = account.name
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, account := range pending {
+		work <- account
+	}
+	close(work)
+	wg.Wait()
+
+	if len(names) > 0 {
+		for accountID, name := range names {
+			index.Add(accountID, name)
+		}
+		data, err := index.Serialize()
+		if err != nil {
+			return err
+		}
+		if err := s.storeAccountsIndex(ctx, walletID, data); err != nil {
+			return err
+		}
+	}
+
+	if len(failed) > 0 || len(unindexed) > 0 {
+		return &StoreAccountsError{
+			Stored:    len(names),
+			Failed:    failed,
+			Unindexed: unindexed,
+		}
+	}
+
+	return nil
+}
+
+// walletIndex returns the account index of a wallet, or an empty index if the wallet does not have one.
+func (s *Store) walletIndex(ctx context.Context, walletID uuid.UUID) (*indexer.Index, error) {
+	data, err := s.retrieveAccountsIndex(ctx, walletID)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return indexer.New(), nil
+		}
+
+		return nil, errors.Wrap(err, "failed to retrieve wallet index")
+	}
+
+	index, err := indexer.Deserialize(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse wallet index")
+	}
+
+	return index, nil
+}
+
+// bulkAccount is an account waiting to be stored as part of a bulk store.
+type bulkAccount struct {
+	id   uuid.UUID
+	name string
+	data []byte
+}
+
+// checkBulkAccount checks that an account can be stored in a wallet with the given index, returning its name.
+func checkBulkAccount(index *indexer.Index, accountID uuid.UUID, data []byte) (string, error) {
+	info := &struct {
+		ID   uuid.UUID `json:"uuid"`
+		Name string    `json:"name"`
+	}{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return "", errors.Wrap(err, "failed to parse account")
+	}
+	if info.ID != accountID {
+		return "", errors.New("account ID does not match data")
+	}
+	if info.Name == "" {
+		return "", errors.New("account has no name")
+	}
+	if existingID, exists := index.ID(info.Name); exists && existingID != accountID {
+		return "", &DuplicateNameError{Kind: ObjectKindAccount, Name: info.Name, ExistingID: existingID}
+	}
+
+	return info.Name, nil
+}
+
+// storeBulkAccount stores a single account as part of a bulk store, returning its public keys to be indexed.
+// The account index has already been checked for the account's name.
+func (s *Store) storeBulkAccount(ctx context.Context,
+	walletID uuid.UUID,
+	accountID uuid.UUID,
+	name string,
+	data []byte,
+) (
+	[][]byte,
+	error,
+) {
+	pubKeys, err := accountPubKeys(data)
+	if err != nil {
+		return nil, err
+	}
+
+	nameKey := s.accountNamePath(walletID, name)
+	claimed, err := s.claimName(ctx, nameKey, ObjectKindAccount, accountID, name, s.accountHasName(walletID, name))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = s.encryptIfRequired(data)
+	if err != nil {
+		return nil, err
+	}
+
+	path := s.accountPath(walletID, accountID)
+	if err := s.uploadLimited(ctx, path, data, s.withObjectAttributes(ObjectKindAccount), s.withObjectLock()); err != nil {
+		if claimed {
+			s.releaseName(ctx, nameKey)
+		}
+
+		return nil, errors.Wrap(err, "failed to store key")
+	}
+
+	return pubKeys, nil
+}
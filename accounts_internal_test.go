@@ -0,0 +1,215 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetrieveAccountsByIDs(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithPassphrase([]byte("secret")), WithMaxConcurrency(2))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+	accountIDs := make([]uuid.UUID, 0)
+	for i := 0; i < 8; i++ {
+		accountID := uuid.New()
+		require.NoError(t, s.StoreAccount(walletID, accountID,
+			[]byte(fmt.Sprintf(`{"uuid":%q,"name":"account %d"}`, accountID, i))))
+		accountIDs = append(accountIDs, accountID)
+	}
+	// An account that cannot be decrypted.
+	corruptID := uuid.New()
+	m.putObject("bucket", s.accountPath(walletID, corruptID), []byte(`{"uuid":"corrupt"}`))
+
+	missingID := uuid.New()
+	requested := []uuid.UUID{accountIDs[5], missingID, accountIDs[0], corruptID, accountIDs[7]}
+	results := s.RetrieveAccountsByIDs(walletID, requested)
+	require.Len(t, results, len(requested))
+	for i, result := range results {
+		require.Equal(t, requested[i], result.AccountID)
+	}
+
+	require.NoError(t, results[0].Err)
+	require.Equal(t, fmt.Sprintf(`{"uuid":%q,"name":"account 5"}`, accountIDs[5]), string(results[0].Data))
+	require.True(t, isKeyNotFound(results[1].Err))
+	require.Nil(t, results[1].Data)
+	require.NoError(t, results[2].Err)
+	require.Equal(t, fmt.Sprintf(`{"uuid":%q,"name":"account 0"}`, accountIDs[0]), string(results[2].Data))
+	require.Error(t, results[3].Err)
+	require.Nil(t, results[3].Data)
+	require.NoError(t, results[4].Err)
+
+	require.Empty(t, s.RetrieveAccountsByIDs(walletID, nil))
+}
+
+func TestStoreAccounts(t *testing.T) {
+	_, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithMaxConcurrency(4))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	require.EqualError(t, s.StoreAccounts(walletID, nil), "unknown wallet")
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+
+	account := func(accountID uuid.UUID, name string) []byte {
+		return []byte(fmt.Sprintf(`{"uuid":%q,"name":%q}`, accountID, name))
+	}
+	existingID := uuid.New()
+	require.NoError(t, s.StoreAccounts(walletID, map[uuid.UUID][]byte{existingID: account(existingID, "existing")}))
+
+	accounts := make(map[uuid.UUID][]byte)
+	for i := 0; i < 20; i++ {
+		accountID := uuid.New()
+		accounts[accountID] = account(accountID, fmt.Sprintf("account %d", i))
+	}
+	clashID := uuid.New()
+	accounts[clashID] = account(clashID, "existing")
+	mismatchID := uuid.New()
+	accounts[mismatchID] = account(uuid.New(), "mismatch")
+	invalidID := uuid.New()
+	accounts[invalidID] = []byte("{")
+	// Overwriting an existing account is allowed.
+	accounts[existingID] = account(existingID, "existing")
+
+	err = s.StoreAccounts(walletID, accounts)
+	require.EqualError(t, err, "failed to store 3 of 24 accounts")
+	var storeErr *StoreAccountsError
+	require.ErrorAs(t, err, &storeErr)
+	require.Equal(t, 21, storeErr.Stored)
+	require.ErrorIs(t, storeErr.Failed[clashID], ErrDuplicateName)
+	require.EqualError(t, storeErr.Failed[mismatchID], "account ID does not match data")
+	require.Contains(t, storeErr.Failed[invalidID].Error(), "failed to parse account")
+
+	index, err := s.walletIndex(context.Background(), walletID)
+	require.NoError(t, err)
+	for accountID, data := range accounts {
+		_, failed := storeErr.Failed[accountID]
+		require.Equal(t, !failed, index.IDKnown(accountID))
+		if !failed {
+			stored, err := s.RetrieveAccount(walletID, accountID)
+			require.NoError(t, err)
+			require.Equal(t, data, stored)
+		}
+	}
+}
+
+func TestStoreAccountsFailures(t *testing.T) {
+	_, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithMaxConcurrency(4))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+
+	// Accounts that fail their checks before being stored are mixed with accounts that fail while being stored.
+	accounts := make(map[uuid.UUID][]byte)
+	unparseable := make([]uuid.UUID, 0)
+	invalidPubKey := make([]uuid.UUID, 0)
+	for i := 0; i < 16; i++ {
+		accountID := uuid.New()
+		switch i % 3 {
+		case 0:
+			accounts[accountID] = []byte("{")
+			unparseable = append(unparseable, accountID)
+		case 1:
+			accounts[accountID] = []byte(fmt.Sprintf(`{"uuid":%q,"name":"account %d","pubkey":"zz"}`, accountID, i))
+			invalidPubKey = append(invalidPubKey, accountID)
+		default:
+			accounts[accountID] = []byte(fmt.Sprintf(`{"uuid":%q,"name":"account %d"}`, accountID, i))
+		}
+	}
+
+	err = s.StoreAccounts(walletID, accounts)
+	var storeErr *StoreAccountsError
+	require.ErrorAs(t, err, &storeErr)
+	require.Len(t, storeErr.Failed, len(unparseable)+len(invalidPubKey))
+	require.Equal(t, len(accounts)-len(storeErr.Failed), storeErr.Stored)
+	for _, accountID := range unparseable {
+		require.Contains(t, storeErr.Failed[accountID].Error(), "failed to parse account")
+	}
+	for _, accountID := range invalidPubKey {
+		require.Contains(t, storeErr.Failed[accountID].Error(), "invalid public key")
+	}
+}
+
+func TestStoreAccountsConcurrency(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithMaxConcurrency(2))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+
+	accounts := make(map[uuid.UUID][]byte)
+	for i := 0; i < 16; i++ {
+		accountID := uuid.New()
+		accounts[accountID] = []byte(fmt.Sprintf(`{"uuid":%q,"name":"account %d","pubkey":"0x%02x"}`, accountID, i, i))
+	}
+
+	m.setLatency(10 * time.Millisecond)
+	m.resetMaxInFlight()
+	require.NoError(t, s.StoreAccounts(walletID, accounts))
+	require.LessOrEqual(t, m.maxInFlight.Load(), int32(2))
+}
+
+func TestStoreAccountsUnindexed(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+
+	// The public key index cannot be written.
+	m.denyWrites("pubkeys/")
+	withPubKeyID := uuid.New()
+	withoutPubKeyID := uuid.New()
+	accounts := map[uuid.UUID][]byte{
+		withPubKeyID:    []byte(fmt.Sprintf(`{"uuid":%q,"name":"with","pubkey":"0x01"}`, withPubKeyID)),
+		withoutPubKeyID: []byte(fmt.Sprintf(`{"uuid":%q,"name":"without"}`, withoutPubKeyID)),
+	}
+
+	err = s.StoreAccounts(walletID, accounts)
+	require.EqualError(t, err, "failed to index public keys of 1 of 2 accounts")
+	var storeErr *StoreAccountsError
+	require.ErrorAs(t, err, &storeErr)
+	require.Equal(t, 2, storeErr.Stored)
+	require.Empty(t, storeErr.Failed)
+	require.Len(t, storeErr.Unindexed, 1)
+	require.Contains(t, storeErr.Unindexed[withPubKeyID].Error(), "failed to store public key index entry")
+
+	// Both accounts are stored and in the account index.
+	index, err := s.walletIndex(context.Background(), walletID)
+	require.NoError(t, err)
+	for accountID, data := range accounts {
+		require.True(t, index.IDKnown(accountID))
+		stored, err := s.RetrieveAccount(walletID, accountID)
+		require.NoError(t, err)
+		require.Equal(t, data, stored)
+	}
+}
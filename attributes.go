@@ -0,0 +1,125 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// ObjectKind is the kind of data held in an object.
+type ObjectKind string
+
+const (
+	// ObjectKindWallet is a wallet header.
+	ObjectKindWallet ObjectKind = "wallet"
+	// ObjectKindAccount is an account.
+	ObjectKindAccount ObjectKind = "account"
+	// ObjectKindIndex is a wallet's account index.
+	ObjectKindIndex ObjectKind = "index"
+	// ObjectKindBatch is a wallet's batch of accounts.
+	ObjectKindBatch ObjectKind = "batch"
+	// ObjectKindPubKeyIndex is an entry in the index of accounts by public key.
+	ObjectKindPubKeyIndex ObjectKind = "pubkey-index"
+)
+
+const (
+	// maxObjectTags is the maximum number of tags S3 allows on an object.
+	maxObjectTags = 10
+	// metadataObjectKind is the metadata key holding the kind of an object.
+	metadataObjectKind = "object-kind"
+	// metadataFormatVersion is the metadata key holding the store format version of an object.
+	metadataFormatVersion = "format-version"
+)
+
+// ObjectAttributes defines the attributes applied to objects of a given kind when they are written.
+type ObjectAttributes struct {
+	// StorageClass is the storage class of the objects, for example "STANDARD_IA".
+	// If empty the bucket's default storage class is used.
+	StorageClass string
+	// Tags are the tags applied to the objects, for example for cost allocation or lifecycle rules.
+	Tags map[string]string
+	// Metadata is the custom metadata applied to the objects.
+	Metadata map[string]string
+}
+
+// WithObjectAttributes sets the attributes applied to objects of the given kind when they are written.
+// Regardless of this option, each object carries metadata giving its kind and the store's format version.
+func WithObjectAttributes(kind ObjectKind, t ObjectAttributes) Option {
+	return optionFunc(func(o *options) {
+		if o.objectAttributes == nil {
+			o.objectAttributes = make(map[ObjectKind]*ObjectAttributes)
+		}
+		o.objectAttributes[kind] = &t
+	})
+}
+
+// checkObjectAttributes checks that object attributes are valid.
+func checkObjectAttributes(attributes map[ObjectKind]*ObjectAttributes) error {
+	for kind, attrs := range attributes {
+		switch kind {
+		case ObjectKindWallet, ObjectKindAccount, ObjectKindIndex, ObjectKindBatch, ObjectKindPubKeyIndex:
+		default:
+			return fmt.Errorf("unknown object kind %q", kind)
+		}
+		if len(attrs.Tags) > maxObjectTags {
+			return fmt.Errorf("too many tags for %s objects", kind)
+		}
+		for key := range attrs.Metadata {
+			if key == metadataObjectKind || key == metadataFormatVersion {
+				return fmt.Errorf("metadata key %q is reserved", key)
+			}
+		}
+	}
+
+	return nil
+}
+
+// withObjectAttributes is an upload option that applies the attributes for the given kind of object.
+func (s *Store) withObjectAttributes(kind ObjectKind) uploadOption {
+	return func(input *s3manager.UploadInput, data []byte) {
+		if json.Valid(data) {
+			input.ContentType = aws.String("application/json")
+		} else {
+			input.ContentType = aws.String("application/octet-stream")
+		}
+
+		metadata := map[string]*string{
+			metadataObjectKind:    aws.String(string(kind)),
+			metadataFormatVersion: aws.String(strconv.Itoa(manifestVersion)),
+		}
+		attrs, exists := s.objectAttributes[kind]
+		if exists {
+			for key, value := range attrs.Metadata {
+				metadata[key] = aws.String(value)
+			}
+			if attrs.StorageClass != "" {
+				input.StorageClass = aws.String(attrs.StorageClass)
+			}
+			if len(attrs.Tags) > 0 {
+				tags := url.Values{}
+				for key, value := range attrs.Tags {
+					tags.Set(key, value)
+				}
+				input.Tagging = aws.String(tags.Encode())
+			}
+		}
+		input.Metadata = metadata
+	}
+}
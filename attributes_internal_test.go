@@ -0,0 +1,114 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckObjectAttributes(t *testing.T) {
+	tags := make(map[string]string)
+	for i := 0; i < 11; i++ {
+		tags[fmt.Sprintf("tag%d", i)] = "value"
+	}
+
+	tests := []struct {
+		name       string
+		attributes map[ObjectKind]*ObjectAttributes
+		err        string
+	}{
+		{
+			name: "Nil",
+		},
+		{
+			name: "Good",
+			attributes: map[ObjectKind]*ObjectAttributes{
+				ObjectKindAccount: {StorageClass: "STANDARD_IA", Tags: map[string]string{"cost-centre": "validators"}},
+			},
+		},
+		{
+			name: "UnknownKind",
+			attributes: map[ObjectKind]*ObjectAttributes{
+				"keys": {StorageClass: "STANDARD_IA"},
+			},
+			err: `unknown object kind "keys"`,
+		},
+		{
+			name: "TooManyTags",
+			attributes: map[ObjectKind]*ObjectAttributes{
+				ObjectKindBatch: {Tags: tags},
+			},
+			err: "too many tags for batch objects",
+		},
+		{
+			name: "ReservedMetadata",
+			attributes: map[ObjectKind]*ObjectAttributes{
+				ObjectKindWallet: {Metadata: map[string]string{"object-kind": "other"}},
+			},
+			err: `metadata key "object-kind" is reserved`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkObjectAttributes(test.attributes)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestObjectAttributes(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithPassphrase([]byte("secret")),
+		WithObjectAttributes(ObjectKindAccount, ObjectAttributes{
+			StorageClass: "STANDARD_IA",
+			Tags:         map[string]string{"cost-centre": "validators", "data": "key material"},
+			Metadata:     map[string]string{"owner": "staking"},
+		}),
+	)
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	accountID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+	require.NoError(t, s.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"account"}`, accountID))))
+	require.NoError(t, s.StoreAccountsIndex(walletID, []byte("[]")))
+
+	header := m.header("bucket", s.accountPath(walletID, accountID))
+	require.Equal(t, "STANDARD_IA", header.Get("x-amz-storage-class"))
+	require.Equal(t, "cost-centre=validators&data=key+material", header.Get("x-amz-tagging"))
+	require.Equal(t, "staking", header.Get("x-amz-meta-owner"))
+	require.Equal(t, "account", header.Get("x-amz-meta-object-kind"))
+	require.Equal(t, "1", header.Get("x-amz-meta-format-version"))
+	require.Equal(t, "application/octet-stream", header.Get("Content-Type"))
+
+	header = m.header("bucket", s.walletHeaderPath(walletID))
+	require.Empty(t, header.Get("x-amz-storage-class"))
+	require.Empty(t, header.Get("x-amz-tagging"))
+	require.Equal(t, "wallet", header.Get("x-amz-meta-object-kind"))
+
+	// The empty index is not encrypted.
+	header = m.header("bucket", s.walletIndexPath(walletID))
+	require.Equal(t, "index", header.Get("x-amz-meta-object-kind"))
+	require.Equal(t, "application/json", header.Get("Content-Type"))
+}
@@ -0,0 +1,501 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AuditMode defines the action taken on the results of a bucket security audit when the store is opened.
+type AuditMode int
+
+const (
+	// AuditModeNone does not audit the bucket when the store is opened.
+	AuditModeNone AuditMode = iota
+	// AuditModeWarn audits the bucket when the store is opened and logs a warning for each finding.
+	AuditModeWarn
+	// AuditModeFail audits the bucket when the store is opened and returns an *AuditError if there are any findings.
+	AuditModeFail
+)
+
+// AuditCheck is the name of a check carried out by a bucket security audit.
+type AuditCheck string
+
+const (
+	// AuditCheckPublicAccessBlock checks that all public access to the bucket is blocked.
+	AuditCheckPublicAccessBlock AuditCheck = "public-access-block"
+	// AuditCheckPublicACL checks that the bucket's access control list does not grant access to the public.
+	AuditCheckPublicACL AuditCheck = "public-acl"
+	// AuditCheckPublicPolicy checks that the bucket's policy does not grant access to the public.
+	AuditCheckPublicPolicy AuditCheck = "public-policy"
+	// AuditCheckCrossAccountGrant checks that the bucket's access control list does not grant access to other accounts.
+	AuditCheckCrossAccountGrant AuditCheck = "cross-account-grant"
+	// AuditCheckEncryption checks that the bucket has default encryption.
+	AuditCheckEncryption AuditCheck = "encryption"
+	// AuditCheckVersioning checks that the bucket has versioning enabled.
+	AuditCheckVersioning AuditCheck = "versioning"
+	// AuditCheckTLS checks that the bucket's policy denies requests not made over TLS.
+	AuditCheckTLS AuditCheck = "tls"
+)
+
+const (
+	allUsersURI           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	authenticatedUsersURI = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+)
+
+// AuditFinding is a problem found by a bucket security audit.
+type AuditFinding struct {
+	// Check is the check that failed.
+	Check AuditCheck
+	// Detail describes the problem.
+	Detail string
+}
+
+// AuditError is returned when the store is opened with AuditModeFail and the audit of its bucket has findings.
+type AuditError struct {
+	// Bucket is the bucket that was audited.
+	Bucket string
+	// Findings are the problems found.
+	Findings []*AuditFinding
+}
+
+// Error implements the error interface.
+func (e *AuditError) Error() string {
+	details := make([]string, len(e.Findings))
+	for i, finding := range e.Findings {
+		details[i] = finding.Detail
+	}
+
+	return fmt.Sprintf("bucket %s failed security audit: %s", e.Bucket, strings.Join(details, "; "))
+}
+
+// WithAuditOnOpen sets the action taken on the results of a security audit of the bucket when the store is opened.
+// This defaults to AuditModeNone.
+func WithAuditOnOpen(t AuditMode) Option {
+	return optionFunc(func(o *options) {
+		o.auditMode = t
+	})
+}
+
+// Audit inspects the security settings of the store's bucket and returns any problems found.
+// Checks that cannot be carried out, for example because the credentials do not have permission to read the
+// relevant setting or the service does not support it, are returned as findings.
+func (s *Store) Audit() ([]*AuditFinding, error) {
+	ctx, span := s.startSpan(context.Background(), "Audit", attribute.String("aws.s3.bucket", s.bucket))
+	findings, err := s.audit(ctx)
+	endSpan(span, err)
+
+	return findings, err
+}
+
+func (s *Store) audit(ctx context.Context) ([]*AuditFinding, error) {
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+	reqOpts := s.requestOptions(trace.SpanFromContext(ctx), "")
+
+	findings := make([]*AuditFinding, 0)
+	for _, check := range []func(context.Context, []request.Option) (*AuditFinding, error){
+		s.auditPublicAccessBlock,
+		s.auditPolicyStatus,
+		s.auditEncryption,
+		s.auditVersioning,
+		s.auditPolicy,
+	} {
+		finding, err := check(ctx, reqOpts)
+		if err != nil {
+			return nil, err
+		}
+		if finding != nil {
+			findings = append(findings, finding)
+		}
+	}
+
+	aclFindings, err := s.auditACL(ctx, reqOpts)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, aclFindings...)
+
+	return findings, nil
+}
+
+// auditOnOpen audits the bucket when the store is opened, taking the action defined by the audit mode.
+func (s *Store) auditOnOpen(ctx context.Context, mode AuditMode) error {
+	ctx, span := s.startSpan(ctx, "Audit", attribute.String("aws.s3.bucket", s.bucket))
+	findings, err := s.audit(ctx)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+
+	if mode == AuditModeFail {
+		return &AuditError{
+			Bucket:   s.bucket,
+			Findings: findings,
+		}
+	}
+	for _, finding := range findings {
+		s.log.Warn().Str("check", string(finding.Check)).Str("detail", finding.Detail).Msg("Bucket security audit finding")
+	}
+
+	return nil
+}
+
+// uncheckedFinding returns a finding if the error shows that a check could not be carried out, or an error otherwise.
+func uncheckedFinding(check AuditCheck, err error) (*AuditFinding, error) {
+	if errorCode(err) != "AccessDenied" && !isUnsupported(err) {
+		return nil, errors.Wrap(err, fmt.Sprintf("failed to audit %s", check))
+	}
+
+	return &AuditFinding{
+		Check:  check,
+		Detail: fmt.Sprintf("unable to check %s (%s)", check, errorCode(err)),
+	}, nil
+}
+
+func (s *Store) auditPublicAccessBlock(ctx context.Context, reqOpts []request.Option) (*AuditFinding, error) {
+	resp, err := s.client.GetPublicAccessBlockWithContext(ctx, &s3.GetPublicAccessBlockInput{
+		Bucket: aws.String(s.bucket),
+	}, reqOpts...)
+	if err != nil {
+		if errorCode(err) == "NoSuchPublicAccessBlockConfiguration" {
+			return &AuditFinding{
+				Check:  AuditCheckPublicAccessBlock,
+				Detail: "public access is not blocked",
+			}, nil
+		}
+
+		return uncheckedFinding(AuditCheckPublicAccessBlock, err)
+	}
+
+	config := resp.PublicAccessBlockConfiguration
+	unblocked := make([]string, 0)
+	if !aws.BoolValue(config.BlockPublicAcls) {
+		unblocked = append(unblocked, "BlockPublicAcls")
+	}
+	if !aws.BoolValue(config.IgnorePublicAcls) {
+		unblocked = append(unblocked, "IgnorePublicAcls")
+	}
+	if !aws.BoolValue(config.BlockPublicPolicy) {
+		unblocked = append(unblocked, "BlockPublicPolicy")
+	}
+	if !aws.BoolValue(config.RestrictPublicBuckets) {
+		unblocked = append(unblocked, "RestrictPublicBuckets")
+	}
+	if len(unblocked) > 0 {
+		return &AuditFinding{
+			Check:  AuditCheckPublicAccessBlock,
+			Detail: fmt.Sprintf("public access is not fully blocked (%s disabled)", strings.Join(unblocked, ", ")),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+func (s *Store) auditPolicyStatus(ctx context.Context, reqOpts []request.Option) (*AuditFinding, error) {
+	resp, err := s.client.GetBucketPolicyStatusWithContext(ctx, &s3.GetBucketPolicyStatusInput{
+		Bucket: aws.String(s.bucket),
+	}, reqOpts...)
+	if err != nil {
+		if errorCode(err) == "NoSuchBucketPolicy" {
+			return nil, nil
+		}
+
+		return uncheckedFinding(AuditCheckPublicPolicy, err)
+	}
+	if aws.BoolValue(resp.PolicyStatus.IsPublic) {
+		return &AuditFinding{
+			Check:  AuditCheckPublicPolicy,
+			Detail: "bucket policy grants public access",
+		}, nil
+	}
+
+	return nil, nil
+}
+
+func (s *Store) auditEncryption(ctx context.Context, reqOpts []request.Option) (*AuditFinding, error) {
+	_, err := s.client.GetBucketEncryptionWithContext(ctx, &s3.GetBucketEncryptionInput{
+		Bucket: aws.String(s.bucket),
+	}, reqOpts...)
+	if err != nil {
+		if errorCode(err) == "ServerSideEncryptionConfigurationNotFoundError" {
+			return &AuditFinding{
+				Check:  AuditCheckEncryption,
+				Detail: "bucket has no default encryption",
+			}, nil
+		}
+
+		return uncheckedFinding(AuditCheckEncryption, err)
+	}
+
+	return nil, nil
+}
+
+func (s *Store) auditVersioning(ctx context.Context, reqOpts []request.Option) (*AuditFinding, error) {
+	resp, err := s.client.GetBucketVersioningWithContext(ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(s.bucket),
+	}, reqOpts...)
+	if err != nil {
+		return uncheckedFinding(AuditCheckVersioning, err)
+	}
+	if aws.StringValue(resp.Status) != s3.BucketVersioningStatusEnabled {
+		return &AuditFinding{
+			Check:  AuditCheckVersioning,
+			Detail: "bucket versioning is not enabled",
+		}, nil
+	}
+
+	return nil, nil
+}
+
+func (s *Store) auditPolicy(ctx context.Context, reqOpts []request.Option) (*AuditFinding, error) {
+	noTLS := &AuditFinding{
+		Check:  AuditCheckTLS,
+		Detail: "bucket policy does not deny requests made without TLS",
+	}
+
+	resp, err := s.client.GetBucketPolicyWithContext(ctx, &s3.GetBucketPolicyInput{
+		Bucket: aws.String(s.bucket),
+	}, reqOpts...)
+	if err != nil {
+		if errorCode(err) == "NoSuchBucketPolicy" {
+			return noTLS, nil
+		}
+
+		return uncheckedFinding(AuditCheckTLS, err)
+	}
+
+	requiresTLS, err := policyRequiresTLS(aws.StringValue(resp.Policy), s.bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !requiresTLS {
+		return noTLS, nil
+	}
+
+	return nil, nil
+}
+
+// policyStatement is a statement in a bucket policy.
+type policyStatement struct {
+	Effect    string                                `json:"Effect"`
+	Principal json.RawMessage                       `json:"Principal"`
+	Action    json.RawMessage                       `json:"Action"`
+	Resource  json.RawMessage                       `json:"Resource"`
+	Condition map[string]map[string]json.RawMessage `json:"Condition"`
+}
+
+// policyRequiresTLS returns true if the bucket policy contains a statement denying all requests to the bucket and
+// its objects by any principal that are not made over TLS.
+func policyRequiresTLS(policy string, bucket string) (bool, error) {
+	doc := &struct {
+		Statement json.RawMessage `json:"Statement"`
+	}{}
+	if err := json.Unmarshal([]byte(policy), doc); err != nil {
+		return false, errors.Wrap(err, "failed to parse bucket policy")
+	}
+
+	statements := make([]*policyStatement, 0)
+	if err := json.Unmarshal(doc.Statement, &statements); err != nil {
+		// The statement can also be a single object.
+		single := &policyStatement{}
+		if err := json.Unmarshal(doc.Statement, single); err != nil {
+			return false, errors.Wrap(err, "failed to parse bucket policy statement")
+		}
+		statements = append(statements, single)
+	}
+
+	for _, statement := range statements {
+		if statement.Effect != "Deny" ||
+			!statementAppliesToAll(statement, bucket) ||
+			!deniesInsecureTransport(statement.Condition) {
+			continue
+		}
+
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// statementAppliesToAll returns true if a policy statement applies to all principals and S3 actions, for both the
+// bucket and its objects.
+func statementAppliesToAll(statement *policyStatement, bucket string) bool {
+	// The principal can be "*" or {"AWS":"*"}.
+	principals := policyValues(statement.Principal)
+	if len(principals) == 0 {
+		principal := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(statement.Principal, &principal); err == nil {
+			principals = policyValues(principal["AWS"])
+		}
+	}
+	if !containsValue(principals, "*") {
+		return false
+	}
+
+	actions := policyValues(statement.Action)
+	if !containsValue(actions, "s3:*") && !containsValue(actions, "*") {
+		return false
+	}
+
+	// Resources can be in any partition.
+	bucketCovered := false
+	objectsCovered := false
+	for _, resource := range policyValues(statement.Resource) {
+		if resource == "*" {
+			return true
+		}
+		if !strings.HasPrefix(resource, "arn:") {
+			continue
+		}
+		switch {
+		case strings.HasSuffix(resource, fmt.Sprintf(":s3:::%s", bucket)):
+			bucketCovered = true
+		case strings.HasSuffix(resource, fmt.Sprintf(":s3:::%s/*", bucket)):
+			objectsCovered = true
+		}
+	}
+
+	return bucketCovered && objectsCovered
+}
+
+// deniesInsecureTransport returns true if a policy condition matches requests not made over TLS.
+func deniesInsecureTransport(condition map[string]map[string]json.RawMessage) bool {
+	for key, value := range condition["Bool"] {
+		// Condition keys are not case-sensitive.
+		if !strings.EqualFold(key, "aws:SecureTransport") {
+			continue
+		}
+		// The condition value can be a string, a boolean, or an array of either, and matches if any value matches.
+		values := make([]interface{}, 0)
+		if err := json.Unmarshal(value, &values); err != nil {
+			var single interface{}
+			if err := json.Unmarshal(value, &single); err != nil {
+				return false
+			}
+			values = append(values, single)
+		}
+		for _, v := range values {
+			switch v := v.(type) {
+			case bool:
+				if !v {
+					return true
+				}
+			case string:
+				if strings.EqualFold(v, "false") {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// policyValues returns the values of a policy element that can be a single string or an array of strings.
+// It returns nil if the element is neither.
+func policyValues(element json.RawMessage) []string {
+	values := make([]string, 0)
+	if err := json.Unmarshal(element, &values); err == nil {
+		return values
+	}
+	var value string
+	if err := json.Unmarshal(element, &value); err == nil {
+		return []string{value}
+	}
+
+	return nil
+}
+
+// containsValue returns true if the values contain the given value.
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Store) auditACL(ctx context.Context, reqOpts []request.Option) ([]*AuditFinding, error) {
+	resp, err := s.client.GetBucketAclWithContext(ctx, &s3.GetBucketAclInput{
+		Bucket: aws.String(s.bucket),
+	}, reqOpts...)
+	if err != nil {
+		finding, err := uncheckedFinding(AuditCheckPublicACL, err)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*AuditFinding{finding}, nil
+	}
+
+	findings := make([]*AuditFinding, 0)
+	ownerID := ""
+	if resp.Owner != nil {
+		ownerID = aws.StringValue(resp.Owner.ID)
+	}
+	for _, grant := range resp.Grants {
+		if grant.Grantee == nil {
+			continue
+		}
+		permission := aws.StringValue(grant.Permission)
+		switch aws.StringValue(grant.Grantee.Type) {
+		case s3.TypeGroup:
+			switch aws.StringValue(grant.Grantee.URI) {
+			case allUsersURI:
+				findings = append(findings, &AuditFinding{
+					Check:  AuditCheckPublicACL,
+					Detail: fmt.Sprintf("bucket ACL grants %s to all users", permission),
+				})
+			case authenticatedUsersURI:
+				findings = append(findings, &AuditFinding{
+					Check:  AuditCheckPublicACL,
+					Detail: fmt.Sprintf("bucket ACL grants %s to all authenticated AWS users", permission),
+				})
+			}
+		case s3.TypeCanonicalUser:
+			if id := aws.StringValue(grant.Grantee.ID); id != ownerID {
+				findings = append(findings, &AuditFinding{
+					Check:  AuditCheckCrossAccountGrant,
+					Detail: fmt.Sprintf("bucket ACL grants %s to another account (%s)", permission, id),
+				})
+			}
+		case s3.TypeAmazonCustomerByEmail:
+			findings = append(findings, &AuditFinding{
+				Check: AuditCheckCrossAccountGrant,
+				Detail: fmt.Sprintf("bucket ACL grants %s to another account (%s)",
+					permission,
+					aws.StringValue(grant.Grantee.EmailAddress),
+				),
+			})
+		}
+	}
+
+	return findings, nil
+}
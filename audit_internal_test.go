@@ -0,0 +1,405 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+const tlsOnlyTestPolicy = `{"Version":"2012-10-17","Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*",` +
+	`"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"],"Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`
+
+// auditClient is an S3 client that returns fixed bucket security settings.
+type auditClient struct {
+	s3iface.S3API
+	publicAccessBlock *s3.PublicAccessBlockConfiguration
+	publicPolicy      bool
+	encrypted         bool
+	versioning        string
+	policy            string
+	grants            []*s3.Grant
+	aclErr            error
+}
+
+func (c *auditClient) GetPublicAccessBlockWithContext(_ aws.Context,
+	_ *s3.GetPublicAccessBlockInput,
+	_ ...request.Option,
+) (
+	*s3.GetPublicAccessBlockOutput,
+	error,
+) {
+	if c.publicAccessBlock == nil {
+		return nil, awserr.New("NoSuchPublicAccessBlockConfiguration", "not found", nil)
+	}
+
+	return &s3.GetPublicAccessBlockOutput{PublicAccessBlockConfiguration: c.publicAccessBlock}, nil
+}
+
+func (c *auditClient) GetBucketPolicyStatusWithContext(_ aws.Context,
+	_ *s3.GetBucketPolicyStatusInput,
+	_ ...request.Option,
+) (
+	*s3.GetBucketPolicyStatusOutput,
+	error,
+) {
+	if c.policy == "" {
+		return nil, awserr.New("NoSuchBucketPolicy", "not found", nil)
+	}
+
+	return &s3.GetBucketPolicyStatusOutput{PolicyStatus: &s3.PolicyStatus{IsPublic: aws.Bool(c.publicPolicy)}}, nil
+}
+
+func (c *auditClient) GetBucketEncryptionWithContext(_ aws.Context,
+	_ *s3.GetBucketEncryptionInput,
+	_ ...request.Option,
+) (
+	*s3.GetBucketEncryptionOutput,
+	error,
+) {
+	if !c.encrypted {
+		return nil, awserr.New("ServerSideEncryptionConfigurationNotFoundError", "not found", nil)
+	}
+
+	return &s3.GetBucketEncryptionOutput{}, nil
+}
+
+func (c *auditClient) GetBucketVersioningWithContext(_ aws.Context,
+	_ *s3.GetBucketVersioningInput,
+	_ ...request.Option,
+) (
+	*s3.GetBucketVersioningOutput,
+	error,
+) {
+	output := &s3.GetBucketVersioningOutput{}
+	if c.versioning != "" {
+		output.Status = aws.String(c.versioning)
+	}
+
+	return output, nil
+}
+
+func (c *auditClient) GetBucketPolicyWithContext(_ aws.Context,
+	_ *s3.GetBucketPolicyInput,
+	_ ...request.Option,
+) (
+	*s3.GetBucketPolicyOutput,
+	error,
+) {
+	if c.policy == "" {
+		return nil, awserr.New("NoSuchBucketPolicy", "not found", nil)
+	}
+
+	return &s3.GetBucketPolicyOutput{Policy: aws.String(c.policy)}, nil
+}
+
+func (c *auditClient) GetBucketAclWithContext(_ aws.Context,
+	_ *s3.GetBucketAclInput,
+	_ ...request.Option,
+) (
+	*s3.GetBucketAclOutput,
+	error,
+) {
+	if c.aclErr != nil {
+		return nil, c.aclErr
+	}
+
+	return &s3.GetBucketAclOutput{
+		Owner:  &s3.Owner{ID: aws.String("owner")},
+		Grants: c.grants,
+	}, nil
+}
+
+func ownerGrant() *s3.Grant {
+	return &s3.Grant{
+		Grantee:    &s3.Grantee{Type: aws.String(s3.TypeCanonicalUser), ID: aws.String("owner")},
+		Permission: aws.String(s3.PermissionFullControl),
+	}
+}
+
+func TestAudit(t *testing.T) {
+	secure := func() *auditClient {
+		return &auditClient{
+			publicAccessBlock: &s3.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(true),
+				IgnorePublicAcls:      aws.Bool(true),
+				BlockPublicPolicy:     aws.Bool(true),
+				RestrictPublicBuckets: aws.Bool(true),
+			},
+			encrypted:  true,
+			versioning: s3.BucketVersioningStatusEnabled,
+			policy:     tlsOnlyTestPolicy,
+			grants:     []*s3.Grant{ownerGrant()},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		client   func() *auditClient
+		err      string
+		findings []*AuditFinding
+	}{
+		{
+			name:     "Secure",
+			client:   secure,
+			findings: []*AuditFinding{},
+		},
+		{
+			name: "Default",
+			client: func() *auditClient {
+				return &auditClient{grants: []*s3.Grant{ownerGrant()}}
+			},
+			findings: []*AuditFinding{
+				{Check: AuditCheckPublicAccessBlock, Detail: "public access is not blocked"},
+				{Check: AuditCheckEncryption, Detail: "bucket has no default encryption"},
+				{Check: AuditCheckVersioning, Detail: "bucket versioning is not enabled"},
+				{Check: AuditCheckTLS, Detail: "bucket policy does not deny requests made without TLS"},
+			},
+		},
+		{
+			name: "Exposed",
+			client: func() *auditClient {
+				client := secure()
+				client.publicAccessBlock.BlockPublicPolicy = aws.Bool(false)
+				client.publicAccessBlock.RestrictPublicBuckets = aws.Bool(false)
+				client.publicPolicy = true
+				client.versioning = s3.BucketVersioningStatusSuspended
+				client.grants = append(client.grants,
+					&s3.Grant{
+						Grantee:    &s3.Grantee{Type: aws.String(s3.TypeGroup), URI: aws.String(allUsersURI)},
+						Permission: aws.String(s3.PermissionRead),
+					},
+					&s3.Grant{
+						Grantee:    &s3.Grantee{Type: aws.String(s3.TypeCanonicalUser), ID: aws.String("other")},
+						Permission: aws.String(s3.PermissionWrite),
+					},
+				)
+
+				return client
+			},
+			findings: []*AuditFinding{
+				{
+					Check:  AuditCheckPublicAccessBlock,
+					Detail: "public access is not fully blocked (BlockPublicPolicy, RestrictPublicBuckets disabled)",
+				},
+				{Check: AuditCheckPublicPolicy, Detail: "bucket policy grants public access"},
+				{Check: AuditCheckVersioning, Detail: "bucket versioning is not enabled"},
+				{Check: AuditCheckPublicACL, Detail: "bucket ACL grants READ to all users"},
+				{Check: AuditCheckCrossAccountGrant, Detail: "bucket ACL grants WRITE to another account (other)"},
+			},
+		},
+		{
+			name: "ACLAccessDenied",
+			client: func() *auditClient {
+				client := secure()
+				client.aclErr = awserr.New("AccessDenied", "Access Denied", nil)
+
+				return client
+			},
+			findings: []*AuditFinding{
+				{Check: AuditCheckPublicACL, Detail: "unable to check public-acl (AccessDenied)"},
+			},
+		},
+		{
+			name: "ACLFailed",
+			client: func() *auditClient {
+				client := secure()
+				client.aclErr = errors.New("connection reset")
+
+				return client
+			},
+			err: "failed to audit public-acl: connection reset",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Store{
+				client: test.client(),
+				log:    zerolog.Nop(),
+				bucket: "bucket",
+			}
+			findings, err := s.Audit()
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, test.findings, findings)
+			}
+		})
+	}
+}
+
+func TestAuditOnOpen(t *testing.T) {
+	var buf bytes.Buffer
+	s := &Store{
+		client: &auditClient{
+			encrypted:  true,
+			versioning: s3.BucketVersioningStatusEnabled,
+			policy:     tlsOnlyTestPolicy,
+		},
+		log:    zerolog.New(&buf),
+		bucket: "bucket",
+	}
+
+	require.NoError(t, s.auditOnOpen(context.Background(), AuditModeWarn))
+	require.Contains(t, buf.String(), `"check":"public-access-block"`)
+
+	err := s.auditOnOpen(context.Background(), AuditModeFail)
+	require.EqualError(t, err, "bucket bucket failed security audit: public access is not blocked")
+	var auditErr *AuditError
+	require.True(t, errors.As(err, &auditErr))
+	require.Len(t, auditErr.Findings, 1)
+}
+
+func TestPolicyRequiresTLS(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   string
+		err      string
+		requires bool
+	}{
+		{
+			name:   "Invalid",
+			policy: `not json`,
+			err:    "failed to parse bucket policy: invalid character 'o' in literal null (expecting 'u')",
+		},
+		{
+			name:   "NoTLSCondition",
+			policy: `{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject"}]}`,
+		},
+		{
+			name:   "AllowWithTLSCondition",
+			policy: `{"Statement":[{"Effect":"Allow","Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`,
+		},
+		{
+			name:     "Array",
+			policy:   tlsOnlyTestPolicy,
+			requires: true,
+		},
+		{
+			name: "SingleStatement",
+			policy: `{"Statement":{"Effect":"Deny","Principal":"*","Action":"s3:*",` +
+				`"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"],"Condition":{"Bool":{"aws:SecureTransport":false}}}}`,
+			requires: true,
+		},
+		{
+			name: "ConditionArray",
+			policy: `{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*",` +
+				`"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"],"Condition":{"Bool":{"aws:SecureTransport":["false"]}}}]}`,
+			requires: true,
+		},
+		{
+			name: "AWSPrincipal",
+			policy: `{"Statement":[{"Effect":"Deny","Principal":{"AWS":"*"},"Action":["s3:*"],` +
+				`"Resource":["arn:aws:s3:::bucket/*","arn:aws:s3:::bucket"],"Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`,
+			requires: true,
+		},
+		{
+			name: "OtherPartition",
+			policy: `{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*",` +
+				`"Resource":["arn:aws-cn:s3:::bucket","arn:aws-cn:s3:::bucket/*"],"Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`,
+			requires: true,
+		},
+		{
+			name: "ConditionTrue",
+			policy: `{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*",` +
+				`"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"],"Condition":{"Bool":{"aws:SecureTransport":"true"}}}]}`,
+		},
+		{
+			name: "ConditionSubstring",
+			policy: `{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*",` +
+				`"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"],"Condition":{"Bool":{"aws:SecureTransport":"notfalse"}}}]}`,
+		},
+		{
+			name: "OtherCondition",
+			policy: `{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*",` +
+				`"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"],"Condition":{"Bool":{"aws:MultiFactorAuthPresent":"false"}}}]}`,
+		},
+		{
+			name: "SpecificPrincipal",
+			policy: `{"Statement":[{"Effect":"Deny","Principal":{"AWS":"arn:aws:iam::123456789012:root"},"Action":"s3:*",` +
+				`"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"],"Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`,
+		},
+		{
+			name: "NoPrincipal",
+			policy: `{"Statement":[{"Effect":"Deny","Action":"s3:*",` +
+				`"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"],"Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`,
+		},
+		{
+			name: "SingleAction",
+			policy: `{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:PutObject",` +
+				`"Resource":["arn:aws:s3:::bucket","arn:aws:s3:::bucket/*"],"Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`,
+		},
+		{
+			name: "OtherBucket",
+			policy: `{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*",` +
+				`"Resource":["arn:aws:s3:::other","arn:aws:s3:::other/*"],"Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`,
+		},
+		{
+			name: "BucketPrefix",
+			policy: `{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*",` +
+				`"Resource":["arn:aws:s3:::otherbucket","arn:aws:s3:::otherbucket/*"],"Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`,
+		},
+		{
+			name: "ObjectsOnly",
+			policy: `{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*",` +
+				`"Resource":"arn:aws:s3:::bucket/*","Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`,
+		},
+		{
+			name: "BucketOnly",
+			policy: `{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*",` +
+				`"Resource":"arn:aws:s3:::bucket","Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`,
+		},
+		{
+			name: "SplitStatements",
+			policy: `{"Statement":[{"Effect":"Deny","Principal":"*","Action":"s3:*",` +
+				`"Resource":"arn:aws:s3:::bucket","Condition":{"Bool":{"aws:SecureTransport":"false"}}},` +
+				`{"Effect":"Deny","Principal":"*","Action":"s3:GetObject",` +
+				`"Resource":"arn:aws:s3:::bucket/*","Condition":{"Bool":{"aws:SecureTransport":"false"}}}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requires, err := policyRequiresTLS(test.policy, "bucket")
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, test.requires, requires)
+			}
+		})
+	}
+}
+
+func TestProvisionedPolicyRequiresTLS(t *testing.T) {
+	for _, region := range []string{"us-east-1", "cn-north-1"} {
+		policy, err := tlsOnlyPolicy("bucket", region)
+		require.NoError(t, err)
+		requires, err := policyRequiresTLS(policy, "bucket")
+		require.NoError(t, err)
+		require.True(t, requires)
+	}
+}
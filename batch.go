@@ -14,20 +14,31 @@
 package s3
 
 import (
-	"bytes"
 	"context"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // StoreBatch stores wallet batch data.  It will fail if it cannot store the data.
-func (s *Store) StoreBatch(_ context.Context, walletID uuid.UUID, _ string, data []byte) error {
+func (s *Store) StoreBatch(ctx context.Context, walletID uuid.UUID, _ string, data []byte) error {
+	ctx, span := s.startSpan(ctx, "StoreBatch",
+		attribute.String("wallet_id", walletID.String()),
+	)
+	err := s.storeBatch(ctx, walletID, data)
+	endSpan(span, err)
+
+	return err
+}
+
+func (s *Store) storeBatch(ctx context.Context, walletID uuid.UUID, data []byte) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
 	// Ensure wallet exists.
-	_, err := s.RetrieveWalletByID(walletID)
+	_, err := s.retrieveWalletByID(ctx, walletID)
 	if err != nil {
 		return err
 	}
@@ -37,13 +48,7 @@ func (s *Store) StoreBatch(_ context.Context, walletID uuid.UUID, _ string, data
 	if err != nil {
 		return errors.Wrap(err, "failed to encrypt batch")
 	}
-	uploader := s3manager.NewUploader(s.session)
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(path),
-		Body:   bytes.NewReader(data),
-	})
-	if err != nil {
+	if err := s.upload(ctx, path, data, s.withObjectAttributes(ObjectKindBatch)); err != nil {
 		return errors.Wrap(err, "failed to store batch")
 	}
 
@@ -51,25 +56,29 @@ func (s *Store) StoreBatch(_ context.Context, walletID uuid.UUID, _ string, data
 }
 
 // RetrieveBatch retrieves the batch of accounts for a given wallet.
-func (s *Store) RetrieveBatch(_ context.Context, walletID uuid.UUID) ([]byte, error) {
+func (s *Store) RetrieveBatch(ctx context.Context, walletID uuid.UUID) ([]byte, error) {
+	ctx, span := s.startSpan(ctx, "RetrieveBatch",
+		attribute.String("wallet_id", walletID.String()),
+	)
+	data, err := s.retrieveBatch(ctx, walletID)
+	endSpan(span, err)
+
+	return data, err
+}
+
+func (s *Store) retrieveBatch(ctx context.Context, walletID uuid.UUID) ([]byte, error) {
 	// Ensure wallet exists.
-	_, err := s.RetrieveWalletByID(walletID)
+	_, err := s.retrieveWalletByID(ctx, walletID)
 	if err != nil {
 		return nil, err
 	}
 
 	path := s.walletBatchPath(walletID)
-
-	buf := aws.NewWriteAtBuffer([]byte{})
-	downloader := s3manager.NewDownloader(s.session)
-	if _, err := downloader.Download(buf,
-		&s3.GetObjectInput{
-			Bucket: aws.String(s.bucket),
-			Key:    aws.String(path),
-		}); err != nil {
+	data, err := s.download(ctx, path)
+	if err != nil {
 		return nil, err
 	}
-	data, err := s.decryptIfRequired(buf.Bytes())
+	data, err = s.decrypt(ctx, path, data)
 	if err != nil {
 		return nil, err
 	}
@@ -89,6 +89,7 @@ func TestRetrieveBatchNonExistentWallet(t *testing.T) {
 		s3.WithID([]byte(id)),
 		s3.WithCredentialsID(os.Getenv("S3_CREDENTIALS_ID")),
 		s3.WithCredentialsSecret(os.Getenv("S3_CREDENTIALS_SECRET")),
+		s3.WithCreateDerivedBucket(true),
 	)
 	if err != nil {
 		t.Skip("unable to access S3; skipping test")
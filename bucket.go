@@ -0,0 +1,270 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	util "github.com/wealdtech/go-eth2-util"
+)
+
+// legacyMigrationKey is the key of the object that marks a bucket as the target of an incomplete migration
+// from a legacy bucket.  It contains the name of the legacy bucket.
+const legacyMigrationKey = "legacy-bucket-migration"
+
+// ErrBucketNotFound is returned when no bucket was supplied and no existing bucket could be found for
+// the credentials and ID.
+var ErrBucketNotFound = errors.New("no existing bucket found for store")
+
+// WithCreateDerivedBucket sets whether to create a bucket if no bucket is supplied and no existing bucket is found
+// for the credentials and ID.
+// This defaults to false, in which case New() returns ErrBucketNotFound rather than silently starting an empty store,
+// for example after credentials have changed.
+func WithCreateDerivedBucket(t bool) Option {
+	return optionFunc(func(o *options) {
+		o.createDerivedBucket = t
+	})
+}
+
+// WithLegacyBucketMigration sets whether to migrate a store found in a bucket named with the legacy scheme, which
+// is based on the credentials ID, to a bucket named with the current scheme, which is based on the AWS account.
+// The legacy bucket is left untouched.  If migration is interrupted it is resumed the next time the store is opened
+// with this option.
+// This defaults to false, in which case a store in a legacy bucket is used in place.
+func WithLegacyBucketMigration(t bool) Option {
+	return optionFunc(func(o *options) {
+		o.legacyBucketMigration = t
+	})
+}
+
+// derivedBucketName generates a bucket name from a seed and ID.  This will be the SHA256 hash of a
+// string unique to the seed, as a hex string of 63 charaters (as S3 only allows bucket names up to
+// 63 characters in length).
+func derivedBucketName(seed string, id []byte) string {
+	hash := util.SHA256([]byte(seed), id)
+
+	return hex.EncodeToString(hash)[:63]
+}
+
+// legacyBucketName generates the bucket name for a store under the legacy scheme, which uses the
+// credentials ID and so changes whenever credentials are rotated.
+func legacyBucketName(accessKeyID string, id []byte) string {
+	return derivedBucketName(fmt.Sprintf("Ethereum 2 wallet:%s", accessKeyID), id)
+}
+
+// accountBucketName generates the bucket name for a store from the AWS account ID, which is stable
+// across credential rotation and different credential sources.
+func accountBucketName(accountID string, id []byte) string {
+	return derivedBucketName(fmt.Sprintf("Ethereum 2 wallet account:%s", accountID), id)
+}
+
+// bucketExists returns true if the bucket exists.
+func bucketExists(ctx context.Context,
+	conn s3iface.S3API,
+	bucket string,
+	profile *providerProfile,
+	reqOpts []request.Option,
+) (
+	bool,
+	error,
+) {
+	var err error
+	switch profile.bucketProbe {
+	case probeListObjects:
+		_, err = conn.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(bucket),
+			MaxKeys: aws.Int64(1),
+		}, reqOpts...)
+	default:
+		_, err = conn.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)}, reqOpts...)
+	}
+	if err != nil {
+		if isBucketNotFound(err) {
+			return false, nil
+		}
+
+		return false, errors.Wrap(err, "unable to access bucket")
+	}
+
+	return true, nil
+}
+
+// discoverBucket finds the bucket for a store when one has not been supplied.
+// It returns the name of the bucket and whether or not it already exists.
+func discoverBucket(ctx context.Context,
+	sess *session.Session,
+	conn s3iface.S3API,
+	options *options,
+	reqOpts []request.Option,
+) (
+	string,
+	bool,
+	error,
+) {
+	creds, err := sess.Config.Credentials.Get()
+	if err != nil {
+		return "", false, errors.Wrap(err, "failed to obtain credentials")
+	}
+	legacyBucket := legacyBucketName(creds.AccessKeyID, options.id)
+
+	if !options.providerProfile.accountBuckets {
+		// S3-compatible services do not provide account IDs, so the legacy scheme is used.
+		exists, err := bucketExists(ctx, conn, legacyBucket, options.providerProfile, reqOpts)
+		if err != nil {
+			return "", false, err
+		}
+
+		return legacyBucket, exists, nil
+	}
+
+	identity, err := sts.New(sess).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", false, errors.Wrap(err, "failed to obtain AWS account ID")
+	}
+	bucket := accountBucketName(aws.StringValue(identity.Account), options.id)
+	log := options.logger.With().Str("bucket", bucket).Str("legacy_bucket", legacyBucket).Logger()
+
+	exists, err := bucketExists(ctx, conn, bucket, options.providerProfile, reqOpts)
+	if err != nil {
+		return "", false, err
+	}
+	if exists {
+		if options.legacyBucketMigration {
+			// Resume any incomplete migration.
+			if err := resumeLegacyBucketMigration(ctx, conn, bucket, log, reqOpts); err != nil {
+				return "", false, err
+			}
+		}
+
+		return bucket, true, nil
+	}
+
+	legacyExists, err := bucketExists(ctx, conn, legacyBucket, options.providerProfile, reqOpts)
+	if err != nil {
+		return "", false, err
+	}
+	if !legacyExists {
+		return bucket, false, nil
+	}
+
+	if !options.legacyBucketMigration {
+		log.Warn().Msg("Using store in bucket named with the legacy scheme; this will not be found if credentials change")
+
+		return legacyBucket, true, nil
+	}
+
+	log.Info().Msg("Migrating store from bucket named with the legacy scheme")
+	if err := createBucket(ctx, conn, bucket, options, log, reqOpts); err != nil {
+		return "", false, err
+	}
+	if _, err := conn.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(legacyMigrationKey),
+		Body:   strings.NewReader(legacyBucket),
+	}, reqOpts...); err != nil {
+		return "", false, errors.Wrap(err, "failed to mark migration start")
+	}
+	if err := resumeLegacyBucketMigration(ctx, conn, bucket, log, reqOpts); err != nil {
+		return "", false, err
+	}
+
+	return bucket, true, nil
+}
+
+// resumeLegacyBucketMigration copies objects from a legacy bucket if the bucket is marked as the target
+// of an incomplete migration.
+func resumeLegacyBucketMigration(ctx context.Context,
+	conn s3iface.S3API,
+	bucket string,
+	log zerolog.Logger,
+	reqOpts []request.Option,
+) error {
+	resp, err := conn.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(legacyMigrationKey),
+	}, reqOpts...)
+	if err != nil {
+		if isKeyNotFound(err) {
+			// No migration in progress.
+			return nil
+		}
+
+		return errors.Wrap(err, "failed to check for migration")
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return errors.Wrap(err, "failed to read migration marker")
+	}
+	legacyBucket := string(data)
+
+	copied := 0
+	var copyErr error
+	err = conn.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(legacyBucket),
+	}, func(page *s3.ListObjectsV2Output, _ bool) bool {
+		for _, content := range page.Contents {
+			if _, copyErr = conn.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+				Bucket:     aws.String(bucket),
+				Key:        content.Key,
+				CopySource: aws.String(copySource(legacyBucket, *content.Key)),
+			}, reqOpts...); copyErr != nil {
+				copyErr = errors.Wrap(copyErr, fmt.Sprintf("failed to copy %s", *content.Key))
+				return false
+			}
+			copied++
+		}
+
+		return true
+	}, reqOpts...)
+	if err != nil {
+		return errors.Wrap(err, "failed to list legacy bucket")
+	}
+	if copyErr != nil {
+		return copyErr
+	}
+
+	if _, err := conn.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(legacyMigrationKey),
+	}, reqOpts...); err != nil {
+		return errors.Wrap(err, "failed to mark migration completion")
+	}
+	log.Info().Int("objects", copied).Msg("Migrated store from bucket named with the legacy scheme")
+
+	return nil
+}
+
+// copySource returns the URL-encoded source of an object for a copy request.
+func copySource(bucket string, key string) string {
+	segments := strings.Split(key, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+
+	return fmt.Sprintf("%s/%s", bucket, strings.Join(segments, "/"))
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	util "github.com/wealdtech/go-eth2-util"
+)
+
+func TestBucketNames(t *testing.T) {
+	// The legacy name must match that generated by earlier versions of the store.
+	hash := util.SHA256([]byte("Ethereum 2 wallet:AKIAEXAMPLE"), []byte("test"))
+	require.Equal(t, hex.EncodeToString(hash)[:63], legacyBucketName("AKIAEXAMPLE", []byte("test")))
+
+	legacy := legacyBucketName("AKIAEXAMPLE", nil)
+	rotated := legacyBucketName("AKIAROTATED", nil)
+	require.NotEqual(t, legacy, rotated)
+
+	account := accountBucketName("123456789012", nil)
+	require.Len(t, account, 63)
+	require.NotEqual(t, legacy, account)
+	require.Equal(t, account, accountBucketName("123456789012", nil))
+	require.NotEqual(t, account, accountBucketName("123456789012", []byte("test")))
+}
+
+func TestCopySource(t *testing.T) {
+	tests := []struct {
+		name     string
+		bucket   string
+		key      string
+		expected string
+	}{
+		{
+			name:     "Simple",
+			bucket:   "bucket",
+			key:      "a/b",
+			expected: "bucket/a/b",
+		},
+		{
+			name:     "Escaped",
+			bucket:   "bucket",
+			key:      "a b/c+d",
+			expected: "bucket/a%20b/c+d",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.expected, copySource(test.bucket, test.key))
+		})
+	}
+}
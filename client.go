@@ -0,0 +1,129 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+)
+
+// WithSession sets an existing AWS session for the store to use in place of creating its own.
+// The session's credentials, region, endpoint, HTTP client and handlers are used as-is, so this cannot be used
+// alongside options that configure credentials or the HTTP client.
+func WithSession(t *session.Session) Option {
+	return optionFunc(func(o *options) {
+		o.session = t
+	})
+}
+
+// WithS3Client sets an existing S3 client for the store to use in place of creating its own.
+// The client is used as-is, so this cannot be used alongside options that configure credentials or the HTTP
+// client.  As the store has no access to the client's credentials a bucket must also be supplied.
+func WithS3Client(t s3iface.S3API) Option {
+	return optionFunc(func(o *options) {
+		o.s3Client = t
+	})
+}
+
+// sessionOptionsSupplied returns true if any options that configure the session have been supplied.
+func sessionOptionsSupplied(options *options) bool {
+	return options.credentialsID != "" ||
+		options.credentialsSecret != "" ||
+		options.credentialsSessionToken != "" ||
+		options.credentialsProvider != nil ||
+		options.profile != "" ||
+		options.assumeRoleARN != "" ||
+		options.webIdentityTokenFile != "" ||
+		options.httpClient != nil ||
+		len(options.caBundle) > 0 ||
+		options.clientCertificate != nil ||
+		options.proxy != "" ||
+		options.connectionPool != nil
+}
+
+// s3Client returns the S3 client for the store, along with the session from which it was created.
+// The session is nil if an S3 client was supplied.
+func s3Client(options *options) (s3iface.S3API, *session.Session, error) {
+	switch {
+	case options.s3Client != nil && options.session != nil:
+		return nil, nil, errors.New("cannot supply both a session and an S3 client")
+	case options.s3Client != nil:
+		if sessionOptionsSupplied(options) {
+			return nil, nil, errors.New("cannot supply credentials or HTTP client options alongside an S3 client")
+		}
+		if options.bucket == "" {
+			return nil, nil, errors.New("a bucket must be supplied alongside an S3 client")
+		}
+		if client, isClient := options.s3Client.(*s3.S3); isClient {
+			useClientConfig(options, &client.Config)
+		}
+
+		return options.s3Client, nil, nil
+	case options.session != nil:
+		if sessionOptionsSupplied(options) {
+			return nil, nil, errors.New("cannot supply credentials or HTTP client options alongside a session")
+		}
+		useClientConfig(options, options.session.Config)
+
+		return s3.New(options.session), options.session, nil
+	}
+
+	sessionConfig := &aws.Config{
+		Endpoint:         aws.String(options.endpoint),
+		S3ForcePathStyle: aws.Bool(options.forcePathStyle),
+	}
+	// The session only uses the region of a profile if none is supplied in its configuration.
+	useProfileRegion := options.profile != "" && !options.regionSet
+	if !useProfileRegion {
+		sessionConfig.Region = aws.String(options.region)
+	}
+	httpClient, err := httpClient(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if httpClient != nil {
+		sessionConfig.HTTPClient = httpClient
+	}
+
+	sess, err := newSession(sessionConfig, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if useProfileRegion {
+		if region := aws.StringValue(sess.Config.Region); region != "" {
+			options.region = region
+		} else {
+			// The profile does not have a region either.
+			sess = sess.Copy(&aws.Config{Region: aws.String(options.region)})
+		}
+	}
+
+	if _, err := sess.Config.Credentials.Get(); err != nil {
+		return nil, nil, err
+	}
+
+	return s3.New(sess), sess, nil
+}
+
+// useClientConfig updates the region, endpoint and path style options to match those of a supplied client.
+func useClientConfig(options *options, config *aws.Config) {
+	if region := aws.StringValue(config.Region); region != "" {
+		options.region = region
+	}
+	options.endpoint = aws.StringValue(config.Endpoint)
+	options.forcePathStyle = aws.BoolValue(config.S3ForcePathStyle)
+}
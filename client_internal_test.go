@@ -0,0 +1,171 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3Client(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("eu-west-2"),
+		Endpoint:         aws.String("https://s3.example.com"),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	require.NoError(t, err)
+	client := s3.New(sess, &aws.Config{Region: aws.String("ap-south-1")})
+
+	tests := []struct {
+		name           string
+		opts           []Option
+		err            string
+		session        bool
+		region         string
+		endpoint       string
+		forcePathStyle bool
+	}{
+		{
+			name: "SessionAndClient",
+			opts: []Option{WithSession(sess), WithS3Client(client), WithBucket("bucket")},
+			err:  "cannot supply both a session and an S3 client",
+		},
+		{
+			name: "ClientWithCredentials",
+			opts: []Option{WithS3Client(client), WithBucket("bucket"), WithCredentialsID("id")},
+			err:  "cannot supply credentials or HTTP client options alongside an S3 client",
+		},
+		{
+			name: "ClientWithoutBucket",
+			opts: []Option{WithS3Client(client)},
+			err:  "a bucket must be supplied alongside an S3 client",
+		},
+		{
+			name:           "Client",
+			opts:           []Option{WithS3Client(client), WithBucket("bucket"), WithRegion("us-west-1")},
+			region:         "ap-south-1",
+			endpoint:       "https://s3.example.com",
+			forcePathStyle: true,
+		},
+		{
+			name: "SessionWithProxy",
+			opts: []Option{WithSession(sess), WithProxy("http://proxy.example.com:3128")},
+			err:  "cannot supply credentials or HTTP client options alongside a session",
+		},
+		{
+			name:           "Session",
+			opts:           []Option{WithSession(sess)},
+			session:        true,
+			region:         "eu-west-2",
+			endpoint:       "https://s3.example.com",
+			forcePathStyle: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := options{
+				region: defaultRegion,
+			}
+			for _, o := range test.opts {
+				o.apply(&options)
+			}
+			conn, connSession, err := s3Client(&options)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+				require.NotNil(t, conn)
+				if test.session {
+					require.Equal(t, sess, connSession)
+				} else {
+					require.Nil(t, connSession)
+				}
+				require.Equal(t, test.region, options.region)
+				require.Equal(t, test.endpoint, options.endpoint)
+				require.Equal(t, test.forcePathStyle, options.forcePathStyle)
+			}
+		})
+	}
+}
+
+func TestS3ClientProfileRegion(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	require.NoError(t, os.WriteFile(configFile, []byte(`[profile regional]
+region = eu-central-1
+
+[profile unregional]
+output = json
+`), 0o600))
+	credentialsFile := filepath.Join(dir, "credentials")
+	require.NoError(t, os.WriteFile(credentialsFile, []byte(`[regional]
+aws_access_key_id = id
+aws_secret_access_key = secret
+
+[unregional]
+aws_access_key_id = id
+aws_secret_access_key = secret
+`), 0o600))
+	t.Setenv("AWS_CONFIG_FILE", configFile)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", credentialsFile)
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		region string
+	}{
+		{
+			name:   "Profile",
+			opts:   []Option{WithProfile("regional")},
+			region: "eu-central-1",
+		},
+		{
+			name:   "ProfileWithRegion",
+			opts:   []Option{WithProfile("regional"), WithRegion("us-west-2")},
+			region: "us-west-2",
+		},
+		{
+			name:   "ProfileWithoutRegion",
+			opts:   []Option{WithProfile("unregional")},
+			region: defaultRegion,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := options{
+				region:                defaultRegion,
+				assumeRoleSessionName: defaultRoleSessionName,
+			}
+			for _, opt := range test.opts {
+				opt.apply(&options)
+			}
+			_, sess, err := s3Client(&options)
+			require.NoError(t, err)
+			require.Equal(t, test.region, aws.StringValue(sess.Config.Region))
+			require.Equal(t, test.region, options.region)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// limiter limits the number of concurrent requests made to S3.  The limit
+// is adaptive: it halves whenever S3 throttles a request, and increases by
+// one after each limit's worth of requests that complete without throttling.
+type limiter struct {
+	mu        sync.Mutex
+	released  chan struct{}
+	limit     int
+	maxLimit  int
+	inFlight  int
+	successes int
+}
+
+// newLimiter creates a limiter allowing up to maxLimit concurrent requests.
+func newLimiter(maxLimit int) *limiter {
+	if maxLimit < 1 {
+		maxLimit = 1
+	}
+
+	return &limiter{
+		released: make(chan struct{}),
+		limit:    maxLimit,
+		maxLimit: maxLimit,
+	}
+}
+
+// acquire waits until a request may be made.
+func (l *limiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.inFlight < l.limit {
+			l.inFlight++
+			l.mu.Unlock()
+
+			return nil
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+// release marks a request as complete.
+func (l *limiter) release() {
+	l.mu.Lock()
+	l.inFlight--
+	l.successes++
+	if l.successes >= l.limit && l.limit < l.maxLimit {
+		l.limit++
+		l.successes = 0
+	}
+	l.wake()
+	l.mu.Unlock()
+}
+
+// throttled reduces the limit in response to S3 throttling a request.
+func (l *limiter) throttled() {
+	l.mu.Lock()
+	l.limit /= 2
+	if l.limit < 1 {
+		l.limit = 1
+	}
+	l.successes = 0
+	l.mu.Unlock()
+}
+
+// currentLimit returns the current limit.
+func (l *limiter) currentLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.limit
+}
+
+// wake wakes any goroutines waiting to acquire.  Must be called with the lock held.
+func (l *limiter) wake() {
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// withThrottleDetection is a request option that informs the limiter when
+// S3 throttles a request.
+func withThrottleDetection(l *limiter) request.Option {
+	return func(r *request.Request) {
+		r.Handlers.Retry.PushBack(func(r *request.Request) {
+			if r.IsErrorThrottle() {
+				l.throttled()
+			}
+		})
+	}
+}
+
+// maxConcurrency returns the maximum number of concurrent requests the store makes.
+func (s *Store) maxConcurrency() int {
+	if s.limiter == nil {
+		// Stores created without New() do not have a limiter.
+		return downloadConcurrency
+	}
+
+	return s.limiter.maxLimit
+}
@@ -0,0 +1,87 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterAdapts(t *testing.T) {
+	l := newLimiter(8)
+	require.Equal(t, 8, l.currentLimit())
+
+	l.throttled()
+	require.Equal(t, 4, l.currentLimit())
+	l.throttled()
+	l.throttled()
+	l.throttled()
+	require.Equal(t, 1, l.currentLimit())
+
+	// A limit's worth of successful requests increases the limit by one.
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l.acquire(ctx))
+		l.release()
+	}
+	require.Equal(t, 3, l.currentLimit())
+
+	// The limit never exceeds the maximum.
+	for i := 0; i < 100; i++ {
+		require.NoError(t, l.acquire(ctx))
+		l.release()
+	}
+	require.Equal(t, 8, l.currentLimit())
+}
+
+func TestLimiterBounds(t *testing.T) {
+	l := newLimiter(4)
+	ctx := context.Background()
+
+	var inFlight, maxInFlight int32
+	var wg sync.WaitGroup
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, l.acquire(ctx))
+			current := atomic.AddInt32(&inFlight, 1)
+			for {
+				prev := atomic.LoadInt32(&maxInFlight)
+				if current <= prev || atomic.CompareAndSwapInt32(&maxInFlight, prev, current) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+			l.release()
+		}()
+	}
+	wg.Wait()
+	require.LessOrEqual(t, maxInFlight, int32(4))
+}
+
+func TestLimiterContext(t *testing.T) {
+	l := newLimiter(1)
+	require.NoError(t, l.acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, l.acquire(ctx), context.DeadlineExceeded)
+}
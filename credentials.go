@@ -0,0 +1,179 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultRoleSessionName is the session name used when assuming a role if none is supplied.
+	defaultRoleSessionName = "go-eth2-wallet-store-s3"
+	// credentialsExpiryWindow is the time before expiry at which temporary credentials are refreshed.
+	credentialsExpiryWindow = 5 * time.Minute
+)
+
+// WithCredentialsSessionToken sets the session token for temporary credentials.
+// This is used alongside WithCredentialsID() and WithCredentialsSecret().
+func WithCredentialsSessionToken(t string) Option {
+	return optionFunc(func(o *options) {
+		o.credentialsSessionToken = redactedString(t)
+	})
+}
+
+// WithCredentialsProvider sets a provider for the credentials.
+// This cannot be used alongside WithCredentialsID().
+func WithCredentialsProvider(t credentials.Provider) Option {
+	return optionFunc(func(o *options) {
+		o.credentialsProvider = t
+	})
+}
+
+// WithProfile sets the named profile in the shared AWS configuration from which to obtain configuration and credentials.
+func WithProfile(t string) Option {
+	return optionFunc(func(o *options) {
+		o.profile = t
+	})
+}
+
+// WithAssumeRole sets the ARN of a role to assume.
+// The role is assumed using the credentials that would otherwise be used by the store, and the assumed
+// credentials are refreshed automatically before they expire.
+func WithAssumeRole(t string) Option {
+	return optionFunc(func(o *options) {
+		o.assumeRoleARN = t
+	})
+}
+
+// WithAssumeRoleExternalID sets the external ID used when assuming a role.
+func WithAssumeRoleExternalID(t string) Option {
+	return optionFunc(func(o *options) {
+		o.assumeRoleExternalID = t
+	})
+}
+
+// WithAssumeRoleSessionName sets the session name used when assuming a role.
+// This defaults to "go-eth2-wallet-store-s3".
+func WithAssumeRoleSessionName(t string) Option {
+	return optionFunc(func(o *options) {
+		if t != "" {
+			o.assumeRoleSessionName = t
+		}
+	})
+}
+
+// WithWebIdentityTokenFile sets the path to a web identity token, such as that provided by IAM roles for
+// service accounts, which is exchanged for credentials for the role supplied with WithAssumeRole().
+// The token is re-read, and the credentials refreshed, automatically before the credentials expire.
+func WithWebIdentityTokenFile(t string) Option {
+	return optionFunc(func(o *options) {
+		o.webIdentityTokenFile = t
+	})
+}
+
+// baseCredentials returns the credentials supplied directly in the options.
+// It returns nil if no credentials have been supplied, in which case the default credential chain is used.
+func baseCredentials(options *options) (*credentials.Credentials, error) {
+	switch {
+	case options.credentialsProvider != nil && options.credentialsID != "":
+		return nil, errors.New("cannot supply both a credentials provider and a credentials ID")
+	case options.credentialsProvider != nil:
+		return credentials.NewCredentials(options.credentialsProvider), nil
+	case options.credentialsID != "":
+		return credentials.NewStaticCredentials(options.credentialsID,
+			string(options.credentialsSecret),
+			string(options.credentialsSessionToken),
+		), nil
+	case options.credentialsSessionToken != "":
+		return nil, errors.New("cannot supply a credentials session token without a credentials ID")
+	default:
+		return nil, nil
+	}
+}
+
+// roleCredentials returns credentials for the role in the options, obtained using the session.
+// It returns nil if no role has been supplied.
+func roleCredentials(sess *session.Session, options *options) (*credentials.Credentials, error) {
+	if options.assumeRoleARN == "" {
+		if options.webIdentityTokenFile != "" {
+			return nil, errors.New("cannot supply a web identity token file without a role to assume")
+		}
+
+		return nil, nil
+	}
+
+	if options.webIdentityTokenFile != "" {
+		if options.assumeRoleExternalID != "" {
+			return nil, errors.New("cannot supply an external ID with a web identity token file")
+		}
+		provider := stscreds.NewWebIdentityRoleProviderWithOptions(sts.New(sess),
+			options.assumeRoleARN,
+			options.assumeRoleSessionName,
+			stscreds.FetchTokenPath(options.webIdentityTokenFile),
+			func(p *stscreds.WebIdentityRoleProvider) {
+				p.ExpiryWindow = credentialsExpiryWindow
+			},
+		)
+
+		return credentials.NewCredentials(provider), nil
+	}
+
+	return stscreds.NewCredentials(sess, options.assumeRoleARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = options.assumeRoleSessionName
+		if options.assumeRoleExternalID != "" {
+			p.ExternalID = aws.String(options.assumeRoleExternalID)
+		}
+		p.ExpiryWindow = credentialsExpiryWindow
+	}), nil
+}
+
+// newSession creates a session with the credentials specified in the options.
+func newSession(sessionConfig *aws.Config, options *options) (*session.Session, error) {
+	creds, err := baseCredentials(options)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		sessionConfig.Credentials = creds
+	}
+
+	sessionOptions := session.Options{
+		Config: *sessionConfig,
+	}
+	if options.profile != "" {
+		sessionOptions.Profile = options.profile
+		sessionOptions.SharedConfigState = session.SharedConfigEnable
+	}
+	sess, err := session.NewSessionWithOptions(sessionOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err = roleCredentials(sess, options)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
+
+	return sess, nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSessionCredentials(t *testing.T) {
+	provider := &credentials.StaticProvider{
+		Value: credentials.Value{
+			AccessKeyID:     "provider id",
+			SecretAccessKey: "provider secret",
+		},
+	}
+
+	tests := []struct {
+		name  string
+		opts  []Option
+		err   string
+		creds *credentials.Value
+	}{
+		{
+			name: "Static",
+			opts: []Option{
+				WithCredentialsID("id"),
+				WithCredentialsSecret("secret"),
+			},
+			creds: &credentials.Value{
+				AccessKeyID:     "id",
+				SecretAccessKey: "secret",
+			},
+		},
+		{
+			name: "SessionToken",
+			opts: []Option{
+				WithCredentialsID("id"),
+				WithCredentialsSecret("secret"),
+				WithCredentialsSessionToken("token"),
+			},
+			creds: &credentials.Value{
+				AccessKeyID:     "id",
+				SecretAccessKey: "secret",
+				SessionToken:    "token",
+			},
+		},
+		{
+			name: "SessionTokenWithoutID",
+			opts: []Option{
+				WithCredentialsSessionToken("token"),
+			},
+			err: "cannot supply a credentials session token without a credentials ID",
+		},
+		{
+			name: "Provider",
+			opts: []Option{
+				WithCredentialsProvider(provider),
+			},
+			creds: &credentials.Value{
+				AccessKeyID:     "provider id",
+				SecretAccessKey: "provider secret",
+			},
+		},
+		{
+			name: "ProviderAndStatic",
+			opts: []Option{
+				WithCredentialsProvider(provider),
+				WithCredentialsID("id"),
+			},
+			err: "cannot supply both a credentials provider and a credentials ID",
+		},
+		{
+			name: "WebIdentityWithoutRole",
+			opts: []Option{
+				WithWebIdentityTokenFile("/var/run/secrets/token"),
+			},
+			err: "cannot supply a web identity token file without a role to assume",
+		},
+		{
+			name: "WebIdentityWithExternalID",
+			opts: []Option{
+				WithAssumeRole("arn:aws:iam::123456789012:role/test"),
+				WithAssumeRoleExternalID("external"),
+				WithWebIdentityTokenFile("/var/run/secrets/token"),
+			},
+			err: "cannot supply an external ID with a web identity token file",
+		},
+		{
+			name: "AssumeRole",
+			opts: []Option{
+				WithCredentialsProvider(provider),
+				WithAssumeRole("arn:aws:iam::123456789012:role/test"),
+				WithAssumeRoleExternalID("external"),
+				WithAssumeRoleSessionName("test"),
+			},
+		},
+		{
+			name: "WebIdentity",
+			opts: []Option{
+				WithAssumeRole("arn:aws:iam::123456789012:role/test"),
+				WithWebIdentityTokenFile("/var/run/secrets/token"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := options{
+				region:                defaultRegion,
+				assumeRoleSessionName: defaultRoleSessionName,
+			}
+			for _, opt := range test.opts {
+				opt.apply(&options)
+			}
+			sess, err := newSession(&aws.Config{Region: aws.String(options.region)}, &options)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, sess.Config.Credentials)
+			if test.creds != nil {
+				creds, err := sess.Config.Credentials.Get()
+				require.NoError(t, err)
+				require.Equal(t, test.creds.AccessKeyID, creds.AccessKeyID)
+				require.Equal(t, test.creds.SecretAccessKey, creds.SecretAccessKey)
+				require.Equal(t, test.creds.SessionToken, creds.SessionToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	indexer "github.com/wealdtech/go-indexer"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// AccountMatch is an account found by FindAccounts.
+type AccountMatch struct {
+	// WalletID is the ID of the wallet holding the account.
+	WalletID uuid.UUID
+	// AccountID is the ID of the account.
+	AccountID uuid.UUID
+	// Name is the name of the account.
+	Name string
+}
+
+// FindAccounts finds accounts in all wallets whose names match the given glob pattern, as per path.Match(), for
+// example "validator-12*".  Matches are ordered by wallet ID and then account name.
+// Accounts are found using each wallet's account index, so accounts are not downloaded and accounts missing from
+// their wallet's index are not found.
+func (s *Store) FindAccounts(nameGlob string) ([]*AccountMatch, error) {
+	ctx, span := s.startSpan(context.Background(), "FindAccounts",
+		attribute.String("name", nameGlob),
+	)
+	matches, err := s.findAccounts(ctx, nameGlob)
+	endSpan(span, err)
+
+	return matches, err
+}
+
+func (s *Store) findAccounts(ctx context.Context, nameGlob string) ([]*AccountMatch, error) {
+	if _, err := path.Match(nameGlob, ""); err != nil {
+		return nil, errors.Wrap(err, "invalid name pattern")
+	}
+
+	walletIDs, err := s.walletIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Search indices concurrently.
+	matches := make([]*AccountMatch, 0)
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for _, walletID := range walletIDs {
+		wg.Add(1)
+		go func(walletID uuid.UUID) {
+			defer wg.Done()
+			key := s.walletIndexPath(walletID)
+			data, err := s.downloadLimited(ctx, key)
+			if err != nil {
+				if isKeyNotFound(err) {
+					// Wallet has no index.
+					return
+				}
+				s.log.Warn().Str("key", key).Str("code", errorCode(err)).Err(err).Msg("Failed to download index; skipping")
+				return
+			}
+			// Do not decrypt empty index.
+			if len(data) != 2 {
+				data, err = s.decrypt(ctx, key, data)
+				if err != nil {
+					s.log.Warn().Str("key", key).Err(err).Msg("Failed to decrypt index; skipping")
+					return
+				}
+			}
+			walletMatches, err := matchIndex(walletID, data, nameGlob)
+			if err != nil {
+				s.log.Warn().Str("key", key).Err(err).Msg("Failed to search index; skipping")
+				return
+			}
+			mu.Lock()
+			matches = append(matches, walletMatches...)
+			mu.Unlock()
+		}(walletID)
+	}
+	wg.Wait()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].WalletID != matches[j].WalletID {
+			return matches[i].WalletID.String() < matches[j].WalletID.String()
+		}
+
+		return matches[i].Name < matches[j].Name
+	})
+
+	return matches, nil
+}
+
+// matchIndex returns the accounts in a serialized account index whose names match the given glob pattern.
+func matchIndex(walletID uuid.UUID, data []byte, nameGlob string) ([]*AccountMatch, error) {
+	if !strings.ContainsAny(nameGlob, `*?[\`) {
+		// The pattern is a plain name, so can be looked up directly.
+		index, err := indexer.Deserialize(data)
+		if err != nil {
+			return nil, err
+		}
+		accountID, exists := index.ID(nameGlob)
+		if !exists {
+			return nil, nil
+		}
+
+		return []*AccountMatch{{WalletID: walletID, AccountID: accountID, Name: nameGlob}}, nil
+	}
+
+	// The index does not provide iteration, so its serialized entries are searched.
+	var entries []*struct {
+		ID   uuid.UUID `json:"uuid"`
+		Name string    `json:"name"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, errors.Wrap(err, "failed to parse index")
+	}
+	matches := make([]*AccountMatch, 0)
+	for _, entry := range entries {
+		// The pattern has already been checked, so this cannot fail.
+		if matched, _ := path.Match(nameGlob, entry.Name); matched {
+			matches = append(matches, &AccountMatch{WalletID: walletID, AccountID: entry.ID, Name: entry.Name})
+		}
+	}
+
+	return matches, nil
+}
+
+// walletIDs returns the IDs of all wallets in the store.
+func (s *Store) walletIDs(ctx context.Context) ([]uuid.UUID, error) {
+	contents, err := s.listObjects(ctx, s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list objects")
+	}
+
+	walletIDs := make([]uuid.UUID, 0)
+	for _, content := range contents {
+		// This is only a wallet if the last two components of the path are the same.
+		components := strings.Split(*content.Key, "/")
+		if len(components) < 2 || components[len(components)-1] != components[len(components)-2] {
+			continue
+		}
+		walletID, err := uuid.Parse(components[len(components)-1])
+		if err != nil {
+			continue
+		}
+		walletIDs = append(walletIDs, walletID)
+	}
+
+	return walletIDs, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	indexer "github.com/wealdtech/go-indexer"
+)
+
+func TestFindAccounts(t *testing.T) {
+	_, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithPath("store"), WithPassphrase([]byte("secret")))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	// Two wallets with indices, and one without.
+	walletIDs := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	sort.Slice(walletIDs, func(i, j int) bool { return walletIDs[i].String() < walletIDs[j].String() })
+	accountIDs := make(map[string]uuid.UUID)
+	for i, walletID := range walletIDs {
+		require.NoError(t, s.StoreWallet(walletID, fmt.Sprintf("wallet %d", i), []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet %d"}`, walletID, i))))
+		if i == 2 {
+			continue
+		}
+		index := indexer.New()
+		for j := 0; j < 3; j++ {
+			name := fmt.Sprintf("validator-%d%d", i, j)
+			accountIDs[name] = uuid.New()
+			index.Add(accountIDs[name], name)
+		}
+		data, err := index.Serialize()
+		require.NoError(t, err)
+		require.NoError(t, s.StoreAccountsIndex(walletIDs[i], data))
+	}
+
+	tests := []struct {
+		name     string
+		nameGlob string
+		matches  []*AccountMatch
+		err      string
+	}{
+		{
+			name:     "BadPattern",
+			nameGlob: "validator-[",
+			err:      "invalid name pattern: syntax error in pattern",
+		},
+		{
+			name:     "Exact",
+			nameGlob: "validator-12",
+			matches: []*AccountMatch{
+				{WalletID: walletIDs[1], AccountID: accountIDs["validator-12"], Name: "validator-12"},
+			},
+		},
+		{
+			name:     "ExactMissing",
+			nameGlob: "validator-99",
+			matches:  []*AccountMatch{},
+		},
+		{
+			name:     "Glob",
+			nameGlob: "validator-?1",
+			matches: []*AccountMatch{
+				{WalletID: walletIDs[0], AccountID: accountIDs["validator-01"], Name: "validator-01"},
+				{WalletID: walletIDs[1], AccountID: accountIDs["validator-11"], Name: "validator-11"},
+			},
+		},
+		{
+			name:     "All",
+			nameGlob: "*",
+			matches: []*AccountMatch{
+				{WalletID: walletIDs[0], AccountID: accountIDs["validator-00"], Name: "validator-00"},
+				{WalletID: walletIDs[0], AccountID: accountIDs["validator-01"], Name: "validator-01"},
+				{WalletID: walletIDs[0], AccountID: accountIDs["validator-02"], Name: "validator-02"},
+				{WalletID: walletIDs[1], AccountID: accountIDs["validator-10"], Name: "validator-10"},
+				{WalletID: walletIDs[1], AccountID: accountIDs["validator-11"], Name: "validator-11"},
+				{WalletID: walletIDs[1], AccountID: accountIDs["validator-12"], Name: "validator-12"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matches, err := s.FindAccounts(test.nameGlob)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, test.matches, matches)
+			}
+		})
+	}
+}
@@ -14,17 +14,29 @@
 package s3
 
 import (
-	"bytes"
+	"context"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // StoreAccountsIndex stores the account index.
 func (s *Store) StoreAccountsIndex(walletID uuid.UUID, data []byte) error {
+	ctx, span := s.startSpan(context.Background(), "StoreAccountsIndex",
+		attribute.String("wallet_id", walletID.String()),
+	)
+	err := s.storeAccountsIndex(ctx, walletID, data)
+	endSpan(span, err)
+
+	return err
+}
+
+func (s *Store) storeAccountsIndex(ctx context.Context, walletID uuid.UUID, data []byte) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
 	var err error
 
 	// Do not encrypt empty index.
@@ -36,12 +48,7 @@ func (s *Store) StoreAccountsIndex(walletID uuid.UUID, data []byte) error {
 	}
 
 	path := s.walletIndexPath(walletID)
-	uploader := s3manager.NewUploader(s.session)
-	if _, err := uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(path),
-		Body:   bytes.NewReader(data),
-	}); err != nil {
+	if err := s.upload(ctx, path, data, s.withObjectAttributes(ObjectKindIndex)); err != nil {
 		return errors.Wrap(err, "failed to store wallet index")
 	}
 
@@ -50,23 +57,26 @@ func (s *Store) StoreAccountsIndex(walletID uuid.UUID, data []byte) error {
 
 // RetrieveAccountsIndex retrieves the account index.
 func (s *Store) RetrieveAccountsIndex(walletID uuid.UUID) ([]byte, error) {
+	ctx, span := s.startSpan(context.Background(), "RetrieveAccountsIndex",
+		attribute.String("wallet_id", walletID.String()),
+	)
+	data, err := s.retrieveAccountsIndex(ctx, walletID)
+	endSpan(span, err)
+
+	return data, err
+}
+
+func (s *Store) retrieveAccountsIndex(ctx context.Context, walletID uuid.UUID) ([]byte, error) {
 	path := s.walletIndexPath(walletID)
-	buf := aws.NewWriteAtBuffer([]byte{})
-	downloader := s3manager.NewDownloader(s.session)
-	if _, err := downloader.Download(buf,
-		&s3.GetObjectInput{
-			Bucket: aws.String(s.bucket),
-			Key:    aws.String(path),
-		}); err != nil {
+	data, err := s.download(ctx, path)
+	if err != nil {
 		return nil, err
 	}
-	data := buf.Bytes()
 	// Do not decrypt empty index.
 	if len(data) == 2 {
 		return data, nil
 	}
-	var err error
-	if data, err = s.decryptIfRequired(data); err != nil {
+	if data, err = s.decrypt(ctx, path, data); err != nil {
 		return nil, err
 	}
 
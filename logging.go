@@ -0,0 +1,94 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// redactedText is the text logged in place of sensitive values.
+const redactedText = "<redacted>"
+
+// redacted holds sensitive data such as passphrases.  Its contents are
+// never written out when it is logged or formatted.
+type redacted []byte
+
+// String implements fmt.Stringer.
+func (redacted) String() string {
+	return redactedText
+}
+
+// GoString implements fmt.GoStringer.
+func (redacted) GoString() string {
+	return redactedText
+}
+
+// MarshalJSON implements json.Marshaler.
+func (redacted) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redactedText + `"`), nil
+}
+
+// redactedString holds sensitive strings such as credentials.  Its contents
+// are never written out when it is logged or formatted.
+type redactedString string
+
+// String implements fmt.Stringer.
+func (redactedString) String() string {
+	return redactedText
+}
+
+// GoString implements fmt.GoStringer.
+func (redactedString) GoString() string {
+	return redactedText
+}
+
+// MarshalJSON implements json.Marshaler.
+func (redactedString) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + redactedText + `"`), nil
+}
+
+// errorCode returns the AWS error code for an error, if present.
+func errorCode(err error) string {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return aerr.Code()
+	}
+
+	return ""
+}
+
+// withRetryLogging is a request option that logs each retry of a request.
+func withRetryLogging(log zerolog.Logger) request.Option {
+	return func(r *request.Request) {
+		var lastErr error
+		r.Handlers.Retry.PushBack(func(r *request.Request) {
+			lastErr = r.Error
+		})
+		r.Handlers.AfterRetry.PushBack(func(r *request.Request) {
+			if r.Error != nil || lastErr == nil {
+				// Not retrying.
+				return
+			}
+			log.Debug().
+				Str("operation", r.Operation.Name).
+				Int("attempt", r.RetryCount+1).
+				Dur("delay", r.RetryDelay).
+				Str("code", errorCode(lastErr)).
+				Msg("Retrying S3 request")
+		})
+	}
+}
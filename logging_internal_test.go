@@ -0,0 +1,109 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedacted(t *testing.T) {
+	opts := options{
+		passphrase:        redacted("test passphrase"),
+		credentialsSecret: redactedString("test secret"),
+	}
+
+	for _, formatted := range []string{
+		fmt.Sprintf("%s", opts.passphrase),
+		fmt.Sprintf("%v", opts.passphrase),
+		fmt.Sprintf("%#v", opts.passphrase),
+		fmt.Sprintf("%s", opts.credentialsSecret),
+		fmt.Sprintf("%v", opts.credentialsSecret),
+		fmt.Sprintf("%#v", opts.credentialsSecret),
+	} {
+		require.Equal(t, redactedText, formatted)
+	}
+
+	data, err := json.Marshal(map[string]any{
+		"passphrase": opts.passphrase,
+		"secret":     opts.credentialsSecret,
+	})
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "test passphrase")
+	require.NotContains(t, string(data), "test secret")
+
+	buf := new(bytes.Buffer)
+	log := zerolog.New(buf)
+	log.Info().
+		Interface("passphrase", opts.passphrase).
+		Stringer("secret", opts.credentialsSecret).
+		Msg("Test")
+	require.NotContains(t, buf.String(), "test passphrase")
+	require.NotContains(t, buf.String(), "test secret")
+}
+
+func TestRetryLogging(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		SleepDelay:       func(time.Duration) {},
+	})
+	require.NoError(t, err)
+
+	buf := new(bytes.Buffer)
+	_, err = s3.New(sess).HeadBucketWithContext(context.Background(), &s3.HeadBucketInput{Bucket: aws.String("bucket")},
+		withRetryLogging(zerolog.New(buf).Level(zerolog.DebugLevel)),
+	)
+	require.NoError(t, err)
+	require.Contains(t, buf.String(), "Retrying S3 request")
+	require.Contains(t, buf.String(), `"operation":"HeadBucket"`)
+	require.NotContains(t, buf.String(), "secret")
+}
+
+func TestErrorCode(t *testing.T) {
+	awsErr := awserr.New("NoSuchKey", "The specified key does not exist.", nil)
+	require.Equal(t, "NoSuchKey", errorCode(awsErr))
+	require.Equal(t, "NoSuchKey", errorCode(errors.Wrap(awsErr, "failed to download")))
+	require.True(t, isKeyNotFound(errors.Wrap(awserr.NewRequestFailure(awsErr, http.StatusNotFound, "id"), "failed")))
+	require.Equal(t, "", errorCode(errors.New("not an AWS error")))
+	require.Equal(t, "", errorCode(nil))
+}
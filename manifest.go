@@ -0,0 +1,243 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+const (
+	// manifestName is the name of the manifest object, at the root of the store.
+	manifestName = ".manifest"
+	// manifestVersion is the latest format version of the store understood by this module.
+	manifestVersion = 1
+)
+
+// ErrIncompatibleStore is returned when the manifest of a store shows that it cannot be used by this module.
+var ErrIncompatibleStore = errors.New("incompatible store")
+
+// Manifest is the store-level metadata held in the manifest object at the root of the store.
+// The manifest is not encrypted, so that it can be read without the passphrase.
+type Manifest struct {
+	// Version is the format version of the store.
+	Version uint64 `json:"version"`
+	// UUID is a stable identifier for the store.
+	UUID uuid.UUID `json:"uuid"`
+	// Created is the time at which the store was created.
+	Created time.Time `json:"created"`
+	// Encryption is the scheme with which objects in the store are encrypted, or nil if they are not encrypted.
+	Encryption *ManifestEncryption `json:"encryption,omitempty"`
+	// Layout is the layout of keys in the store.
+	Layout *ManifestLayout `json:"layout"`
+	// Migration is the progress of an incomplete migration, or nil if there is none.
+	Migration *ManifestMigration `json:"migration,omitempty"`
+}
+
+// ManifestEncryption defines the scheme with which objects in the store are encrypted.
+type ManifestEncryption struct {
+	// Cipher is the cipher used to encrypt objects.
+	Cipher string `json:"cipher"`
+	// Checksum is the function used to generate the checksum of the key and encrypted objects.
+	Checksum string `json:"checksum"`
+	// KDF is the key derivation function used to generate the key from the passphrase.
+	KDF string `json:"kdf"`
+	// KDFParams are the parameters for the key derivation function.
+	KDFParams map[string]interface{} `json:"kdfparams"`
+}
+
+// ManifestLayout defines the layout of keys in the store, relative to the store's path.
+// Keys contain the placeholders {wallet} and {account} for wallet and account UUIDs respectively.
+type ManifestLayout struct {
+	// Wallet is the key of wallet data.
+	Wallet string `json:"wallet"`
+	// Account is the key of account data.
+	Account string `json:"account"`
+	// Index is the key of a wallet's accounts index.
+	Index string `json:"index"`
+	// Batch is the key of a wallet's account batch.
+	Batch string `json:"batch"`
+}
+
+// ecodecEncryption returns the encryption scheme used by go-ecodec, which encrypts objects when a passphrase is
+// supplied.
+func ecodecEncryption() *ManifestEncryption {
+	return &ManifestEncryption{
+		Cipher:   "aes-128-ctr",
+		Checksum: "sha256",
+		KDF:      "pbkdf2",
+		KDFParams: map[string]interface{}{
+			"c":     262144,
+			"dklen": 32,
+			"prf":   "hmac-sha256",
+		},
+	}
+}
+
+// currentLayout returns the layout of keys used by this module.
+func currentLayout() *ManifestLayout {
+	return &ManifestLayout{
+		Wallet:  "{wallet}/{wallet}",
+		Account: "{wallet}/{account}",
+		Index:   "{wallet}/index",
+		Batch:   "{wallet}/batch",
+	}
+}
+
+// Manifest returns the manifest of the store.
+// It returns nil if the store was created without a manifest by an earlier version of this module.
+func (s *Store) Manifest() *Manifest {
+	return s.manifest
+}
+
+func (s *Store) manifestPath() string {
+	return join(s.path, manifestName)
+}
+
+// newManifest creates a manifest for a new store.
+func (s *Store) newManifest() *Manifest {
+	manifest := &Manifest{
+		Version: manifestVersion,
+		UUID:    uuid.New(),
+		Created: time.Now().UTC().Truncate(time.Second),
+		Layout:  currentLayout(),
+	}
+	if len(s.passphrase) > 0 {
+		manifest.Encryption = ecodecEncryption()
+	}
+
+	return manifest
+}
+
+// loadManifest reads the manifest of the store and checks that the store is compatible with its options.
+// If the store has no manifest and is empty, a manifest is created if create is true.
+func (s *Store) loadManifest(ctx context.Context, create bool) error {
+	manifest, err := s.readManifest(ctx)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		return s.createManifest(ctx, create)
+	}
+	if err := s.checkManifest(manifest); err != nil {
+		return err
+	}
+	s.manifest = manifest
+
+	return nil
+}
+
+// readManifest reads the manifest of the store.
+// It returns nil if the store has no manifest.
+func (s *Store) readManifest(ctx context.Context) (*Manifest, error) {
+	data, err := s.download(ctx, s.manifestPath())
+	if err != nil {
+		if isKeyNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, errors.Wrap(err, "failed to read manifest")
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, errors.Wrap(err, "failed to parse manifest")
+	}
+
+	return manifest, nil
+}
+
+// writeManifest writes the manifest of the store.
+func (s *Store) writeManifest(ctx context.Context, manifest *Manifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return errors.Wrap(err, "failed to generate manifest")
+	}
+	if err := s.upload(ctx, s.manifestPath(), data); err != nil {
+		return errors.Wrap(err, "failed to store manifest")
+	}
+
+	return nil
+}
+
+// createManifest creates the manifest for a store without one, if the store is empty.
+// Stores with data but without a manifest were created by an earlier version of this module, and are left as-is.
+func (s *Store) createManifest(ctx context.Context, create bool) error {
+	if !create || s.readOnly {
+		s.log.Debug().Msg("Store has no manifest")
+		return nil
+	}
+
+	resp, err := s.listObjectsPage(ctx, s.path, nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to check for existing data")
+	}
+	for _, content := range resp.Contents {
+		if !strings.HasSuffix(*content.Key, "/") {
+			s.log.Debug().Msg("Store has data but no manifest; not creating manifest")
+			return nil
+		}
+	}
+
+	manifest := s.newManifest()
+	if err := s.writeManifest(ctx, manifest); err != nil {
+		return err
+	}
+	s.manifest = manifest
+	s.log.Info().Str("store_uuid", manifest.UUID.String()).Msg("Created manifest")
+
+	return nil
+}
+
+// checkManifest checks that the store described by the manifest can be used with the store's options.
+func (s *Store) checkManifest(manifest *Manifest) error {
+	if manifest.Migration != nil {
+		return errors.Wrap(ErrMigrationInProgress, fmt.Sprintf("migration to version %d incomplete", manifest.Migration.To))
+	}
+	if manifest.Version > manifestVersion {
+		return errors.Wrap(ErrIncompatibleStore,
+			fmt.Sprintf("store format version %d is newer than the latest supported version %d", manifest.Version, manifestVersion),
+		)
+	}
+	if manifest.Version < manifestVersion {
+		return errors.Wrap(ErrIncompatibleStore,
+			fmt.Sprintf("store format version %d must be migrated to version %d", manifest.Version, manifestVersion),
+		)
+	}
+	if manifest.Layout == nil || *manifest.Layout != *currentLayout() {
+		return errors.Wrap(ErrIncompatibleStore, "unknown store layout")
+	}
+	encryption := ecodecEncryption()
+	if manifest.Encryption != nil && (manifest.Encryption.Cipher != encryption.Cipher ||
+		manifest.Encryption.KDF != encryption.KDF) {
+		return errors.Wrap(ErrIncompatibleStore, "unknown store encryption")
+	}
+
+	// Objects are only encrypted if a passphrase is supplied, so a store can hold both, and a difference from the
+	// manifest is not an error.
+	switch {
+	case manifest.Encryption == nil && len(s.passphrase) > 0:
+		s.log.Warn().Msg("Store was created without a passphrase but a passphrase was supplied")
+	case manifest.Encryption != nil && len(s.passphrase) == 0:
+		s.log.Warn().Msg("Store was created with a passphrase but no passphrase was supplied")
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifest(t *testing.T) {
+	m, client := newMemoryS3(t)
+
+	// Create a new store.
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithPath("store"))
+	require.NoError(t, err)
+	manifest := store.(*Store).Manifest()
+	require.NotNil(t, manifest)
+	require.Equal(t, uint64(manifestVersion), manifest.Version)
+	require.Nil(t, manifest.Encryption)
+	require.Equal(t, currentLayout(), manifest.Layout)
+
+	stored := &Manifest{}
+	require.NoError(t, json.Unmarshal(m.object("bucket", "store/.manifest"), stored))
+	require.Equal(t, manifest.UUID, stored.UUID)
+
+	// Reopen the store, including read-only.
+	store, err = New(WithS3Client(client), WithBucket("bucket"), WithPath("store"))
+	require.NoError(t, err)
+	require.Equal(t, manifest.UUID, store.(*Store).Manifest().UUID)
+	store, err = Open(WithS3Client(client), WithBucket("bucket"), WithPath("store"), WithReadOnly(true))
+	require.NoError(t, err)
+	require.Equal(t, manifest.UUID, store.(*Store).Manifest().UUID)
+
+	// Open the store with a passphrase.
+	var log bytes.Buffer
+	store, err = New(WithS3Client(client), WithBucket("bucket"), WithPath("store"), WithPassphrase([]byte("secret")),
+		WithLogger(zerolog.New(&log)))
+	require.NoError(t, err)
+	require.Equal(t, manifest.UUID, store.(*Store).Manifest().UUID)
+	require.Contains(t, log.String(), "Store was created without a passphrase but a passphrase was supplied")
+}
+
+func TestManifestEncrypted(t *testing.T) {
+	m, client := newMemoryS3(t)
+
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithPassphrase([]byte("secret")))
+	require.NoError(t, err)
+	require.Equal(t, ecodecEncryption(), store.(*Store).Manifest().Encryption)
+	require.Contains(t, string(m.object("bucket", ".manifest")), `"kdf":"pbkdf2"`)
+
+	// The store can be opened without a passphrase.
+	var log bytes.Buffer
+	_, err = New(WithS3Client(client), WithBucket("bucket"), WithLogger(zerolog.New(&log)))
+	require.NoError(t, err)
+	require.Contains(t, log.String(), "Store was created with a passphrase but no passphrase was supplied")
+}
+
+func TestManifestIncompatible(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		err      string
+	}{
+		{
+			name:     "Invalid",
+			manifest: `{`,
+			err:      "failed to parse manifest: unexpected end of JSON input",
+		},
+		{
+			name: "FutureVersion",
+			manifest: `{"version":2,"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","created":"2023-01-01T00:00:00Z",` +
+				`"layout":{"wallet":"{wallet}/{wallet}","account":"{wallet}/{account}","index":"{wallet}/index","batch":"{wallet}/batch"}}`,
+			err: "store format version 2 is newer than the latest supported version 1: incompatible store",
+		},
+		{
+			name:     "UnknownLayout",
+			manifest: `{"version":1,"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","layout":{"wallet":"wallets/{wallet}"}}`,
+			err:      "unknown store layout: incompatible store",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m, client := newMemoryS3(t)
+			m.putObject("bucket", ".manifest", []byte(test.manifest))
+			_, err := New(WithS3Client(client), WithBucket("bucket"))
+			require.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestManifestLegacyStore(t *testing.T) {
+	m, client := newMemoryS3(t)
+	walletID := "c9958061-63d4-4a80-bcf3-25f3dda22340"
+	m.putObject("bucket", walletID+"/"+walletID, []byte(`{"uuid":"`+walletID+`","name":"wallet"}`))
+
+	// A store with data but no manifest is left as-is.
+	store, err := New(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+	require.Nil(t, store.(*Store).Manifest())
+	require.Nil(t, m.object("bucket", ".manifest"))
+}
@@ -0,0 +1,500 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"crypto/md5" //nolint:gosec
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryS3 is a minimal in-memory implementation of the S3 API, sufficient to exercise the store.
+type memoryS3 struct {
+	mu       sync.Mutex
+	buckets  map[string]map[string][]byte
+	versions map[string][]*memoryVersion
+	headers  map[string]http.Header
+	locks    map[string]*memoryLock
+	clock    time.Time
+	// pageSize is the number of keys returned in each page of a listing if the request does not set max-keys.
+	pageSize int
+	requests int
+	// deniedPrefix is the prefix of keys to which writes are refused, if not empty.
+	deniedPrefix string
+	// latency is the time each request takes before it is served.
+	latency time.Duration
+	// inFlight and maxInFlight track the number of concurrent requests, including those waiting for the lock.
+	inFlight    atomic.Int32
+	maxInFlight atomic.Int32
+}
+
+// memoryLock is the object lock state of an object.
+type memoryLock struct {
+	Mode        string `xml:"Mode,omitempty"`
+	RetainUntil string `xml:"RetainUntilDate,omitempty"`
+	Status      string `xml:"Status,omitempty"`
+}
+
+// memoryVersion is a version of an object, recorded for every write and delete made through the API.
+type memoryVersion struct {
+	id           string
+	data         []byte
+	lastModified time.Time
+	deleteMarker bool
+}
+
+// newMemoryS3 starts an in-memory S3 service and returns a client for it.
+func newMemoryS3(t *testing.T) (*memoryS3, *s3.S3) {
+	t.Helper()
+
+	m := &memoryS3{
+		buckets:  make(map[string]map[string][]byte),
+		versions: make(map[string][]*memoryVersion),
+		headers:  make(map[string]http.Header),
+		locks:    make(map[string]*memoryLock),
+		clock:    time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		pageSize: 1000,
+	}
+	srv := httptest.NewServer(m)
+	t.Cleanup(srv.Close)
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	require.NoError(t, err)
+
+	return m, s3.New(sess)
+}
+
+// object returns the contents of an object, or nil if it does not exist.
+func (m *memoryS3) object(bucket string, key string) []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.buckets[bucket][key]
+}
+
+// header returns the headers of the latest request to write an object.
+func (m *memoryS3) header(bucket string, key string) http.Header {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.headers[bucket+"/"+key]
+}
+
+// keys returns the sorted keys of the objects in a bucket, and whether or not the bucket exists.
+func (m *memoryS3) keys(bucket string) ([]string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	objects, exists := m.buckets[bucket]
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys, exists
+}
+
+// createBucket creates a bucket directly.
+func (m *memoryS3) createBucket(bucket string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.buckets[bucket] = make(map[string][]byte)
+}
+
+// setPageSize sets the number of keys returned in each page of a listing.
+func (m *memoryS3) setPageSize(pageSize int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pageSize = pageSize
+}
+
+// denyWrites refuses writes to keys with the given prefix.
+func (m *memoryS3) denyWrites(prefix string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deniedPrefix = prefix
+}
+
+// advanceClock advances the clock of the service.
+func (m *memoryS3) advanceClock(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clock = m.clock.Add(d)
+}
+
+// setLatency sets the time each request takes before it is served.
+func (m *memoryS3) setLatency(latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.latency = latency
+}
+
+// resetMaxInFlight resets the maximum number of concurrent requests seen.
+func (m *memoryS3) resetMaxInFlight() {
+	m.maxInFlight.Store(0)
+}
+
+// putObject sets the contents of an object directly.
+func (m *memoryS3) putObject(bucket string, key string, data []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.buckets[bucket]; !exists {
+		m.buckets[bucket] = make(map[string][]byte)
+	}
+	m.buckets[bucket][key] = data
+}
+
+func (m *memoryS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	inFlight := m.inFlight.Add(1)
+	defer m.inFlight.Add(-1)
+	for {
+		maxInFlight := m.maxInFlight.Load()
+		if inFlight <= maxInFlight || m.maxInFlight.CompareAndSwap(maxInFlight, inFlight) {
+			break
+		}
+	}
+	m.mu.Lock()
+	latency := m.latency
+	m.mu.Unlock()
+	time.Sleep(latency)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests++
+	w.Header().Set("x-amz-request-id", fmt.Sprintf("request-%d", m.requests))
+	w.Header().Set("x-amz-id-2", fmt.Sprintf("extended-request-%d", m.requests))
+	w.Header().Set("Date", m.clock.Format(http.TimeFormat))
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	objects, bucketExists := m.buckets[bucket]
+
+	switch {
+	case key == "" && r.Method == http.MethodPut:
+		if !bucketExists {
+			m.buckets[bucket] = make(map[string][]byte)
+		}
+	case !bucketExists:
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+	case key == "" && r.Method == http.MethodHead:
+	case key == "" && r.Method == http.MethodGet:
+		if _, versions := r.URL.Query()["versions"]; versions {
+			m.listVersions(w, r, bucket)
+			return
+		}
+		m.list(w, r, bucket, objects)
+	case r.URL.Query().Has("retention") || r.URL.Query().Has("legal-hold"):
+		m.objectLock(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		if m.deniedPrefix != "" && strings.HasPrefix(key, m.deniedPrefix) {
+			writeError(w, http.StatusForbidden, "AccessDenied")
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" {
+			current, exists := objects[key]
+			if !exists {
+				writeError(w, http.StatusNotFound, "NoSuchKey")
+				return
+			}
+			if match != memoryETag(current) {
+				writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+				return
+			}
+		}
+		if r.Header.Get("If-None-Match") == "*" {
+			if _, exists := objects[key]; exists {
+				writeError(w, http.StatusPreconditionFailed, "PreconditionFailed")
+				return
+			}
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "InternalError")
+			return
+		}
+		objects[key] = data
+		m.headers[bucket+"/"+key] = r.Header.Clone()
+		if mode := r.Header.Get("x-amz-object-lock-mode"); mode != "" {
+			m.lock(bucket, key).Mode = mode
+			m.lock(bucket, key).RetainUntil = r.Header.Get("x-amz-object-lock-retain-until-date")
+		}
+		if status := r.Header.Get("x-amz-object-lock-legal-hold"); status != "" {
+			m.lock(bucket, key).Status = status
+		}
+		w.Header().Set("ETag", memoryETag(data))
+		w.Header().Set("x-amz-version-id", m.addVersion(bucket, key, data, false))
+	case r.Method == http.MethodDelete:
+		delete(objects, key)
+		w.Header().Set("x-amz-version-id", m.addVersion(bucket, key, nil, true))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, exists := objects[key]
+		if versionID := r.URL.Query().Get("versionId"); versionID != "" {
+			data, exists = m.version(bucket, key, versionID)
+		}
+		if !exists {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", memoryETag(data))
+		w.Header().Set("Last-Modified", m.lastModified(bucket, key, r.URL.Query().Get("versionId")).Format(http.TimeFormat))
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+// memoryETag returns the ETag of an object with the given data.
+func memoryETag(data []byte) string {
+	return fmt.Sprintf("%q", fmt.Sprintf("%x", md5.Sum(data))) //nolint:gosec
+}
+
+// list implements ListObjectsV2.
+func (m *memoryS3) list(w http.ResponseWriter, r *http.Request, bucket string, objects map[string][]byte) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	startAfter := query.Get("start-after")
+	if token := query.Get("continuation-token"); token != "" {
+		startAfter = token
+	}
+	maxKeys := m.pageSize
+	if query.Get("max-keys") != "" {
+		maxKeys, _ = strconv.Atoi(query.Get("max-keys"))
+	}
+	delimiter := query.Get("delimiter")
+
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		if strings.HasPrefix(key, prefix) && key > startAfter {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key  string `xml:"Key"`
+		Size int    `xml:"Size"`
+	}
+	type commonPrefix struct {
+		Prefix string `xml:"Prefix"`
+	}
+	result := struct {
+		XMLName               xml.Name       `xml:"ListBucketResult"`
+		Name                  string         `xml:"Name"`
+		Prefix                string         `xml:"Prefix"`
+		KeyCount              int            `xml:"KeyCount"`
+		IsTruncated           bool           `xml:"IsTruncated"`
+		NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+		Contents              []content      `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}{
+		Name:   bucket,
+		Prefix: prefix,
+	}
+	last := ""
+	for _, key := range keys {
+		entry := key
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				entry = key[:len(prefix)+i+len(delimiter)]
+				if entry == last || entry <= startAfter {
+					// Already rolled up into this common prefix.
+					continue
+				}
+			}
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = last
+			break
+		}
+		if entry == key {
+			result.Contents = append(result.Contents, content{Key: key, Size: len(objects[key])})
+		} else {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: entry})
+		}
+		result.KeyCount++
+		last = entry
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// lock returns the object lock state of an object, creating it if required.
+func (m *memoryS3) lock(bucket string, key string) *memoryLock {
+	if _, exists := m.locks[bucket+"/"+key]; !exists {
+		m.locks[bucket+"/"+key] = &memoryLock{}
+	}
+
+	return m.locks[bucket+"/"+key]
+}
+
+// objectLock implements the retention and legal hold requests for an object.
+func (m *memoryS3) objectLock(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	lock := m.lock(bucket, key)
+	if r.Method == http.MethodPut {
+		if r.Header.Get("Content-MD5") == "" {
+			writeError(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+		update := &memoryLock{}
+		if err := xml.NewDecoder(r.Body).Decode(update); err != nil {
+			writeError(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		if r.URL.Query().Has("retention") {
+			lock.Mode = update.Mode
+			lock.RetainUntil = update.RetainUntil
+		} else {
+			lock.Status = update.Status
+		}
+
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	switch {
+	case r.URL.Query().Has("retention") && lock.Mode != "":
+		_, _ = fmt.Fprintf(w, "<Retention><Mode>%s</Mode><RetainUntilDate>%s</RetainUntilDate></Retention>",
+			lock.Mode, lock.RetainUntil)
+	case r.URL.Query().Has("legal-hold") && lock.Status != "":
+		_, _ = fmt.Fprintf(w, "<LegalHold><Status>%s</Status></LegalHold>", lock.Status)
+	default:
+		writeError(w, http.StatusNotFound, "NoSuchObjectLockConfiguration")
+	}
+}
+
+// addVersion records a version of an object, returning its ID.
+func (m *memoryS3) addVersion(bucket string, key string, data []byte, deleteMarker bool) string {
+	m.clock = m.clock.Add(time.Second)
+	version := &memoryVersion{
+		id:           fmt.Sprintf("v%d", m.clock.Unix()),
+		data:         data,
+		lastModified: m.clock,
+		deleteMarker: deleteMarker,
+	}
+	m.versions[bucket+"/"+key] = append(m.versions[bucket+"/"+key], version)
+
+	return version.id
+}
+
+// lastModified returns the time the given version of an object was written, or the current version if the version
+// ID is empty.  Objects set directly were written at the current time.
+func (m *memoryS3) lastModified(bucket string, key string, versionID string) time.Time {
+	versions := m.versions[bucket+"/"+key]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versionID == "" || versions[i].id == versionID {
+			return versions[i].lastModified
+		}
+	}
+
+	return m.clock
+}
+
+// version returns the data of the given version of an object.
+func (m *memoryS3) version(bucket string, key string, versionID string) ([]byte, bool) {
+	for _, version := range m.versions[bucket+"/"+key] {
+		if version.id == versionID && !version.deleteMarker {
+			return version.data, true
+		}
+	}
+
+	return nil, false
+}
+
+// listVersions implements ListObjectVersions.
+func (m *memoryS3) listVersions(w http.ResponseWriter, r *http.Request, bucket string) {
+	prefix := r.URL.Query().Get("prefix")
+
+	type version struct {
+		Key          string `xml:"Key"`
+		VersionID    string `xml:"VersionId"`
+		IsLatest     bool   `xml:"IsLatest"`
+		LastModified string `xml:"LastModified"`
+		Size         int    `xml:"Size,omitempty"`
+	}
+	result := struct {
+		XMLName       xml.Name  `xml:"ListVersionsResult"`
+		Name          string    `xml:"Name"`
+		Prefix        string    `xml:"Prefix"`
+		IsTruncated   bool      `xml:"IsTruncated"`
+		Versions      []version `xml:"Version"`
+		DeleteMarkers []version `xml:"DeleteMarker"`
+	}{
+		Name:   bucket,
+		Prefix: prefix,
+	}
+	for path, versions := range m.versions {
+		key := strings.TrimPrefix(path, bucket+"/")
+		if key == path || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for i := len(versions) - 1; i >= 0; i-- {
+			entry := version{
+				Key:          key,
+				VersionID:    versions[i].id,
+				IsLatest:     i == len(versions)-1,
+				LastModified: versions[i].lastModified.Format("2006-01-02T15:04:05.000Z"),
+				Size:         len(versions[i].data),
+			}
+			if versions[i].deleteMarker {
+				result.DeleteMarkers = append(result.DeleteMarkers, entry)
+			} else {
+				result.Versions = append(result.Versions, entry)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// writeError writes an S3 error response.
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_, _ = fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
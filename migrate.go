@@ -0,0 +1,323 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// ErrMigrationInProgress is returned when a store is opened while a migration of its format is incomplete.
+// The migration can be resumed with Migrate().
+var ErrMigrationInProgress = errors.New("store migration in progress")
+
+// MigrationReport describes the changes made, or that would be made, by a migration.
+type MigrationReport struct {
+	// FromVersion is the format version of the store before the migration.
+	FromVersion uint64
+	// ToVersion is the format version of the store after the migration.
+	ToVersion uint64
+	// DryRun is true if the changes were not made.
+	DryRun bool
+	// Changes are the changes to the store, in the order in which they are made.
+	Changes []*MigrationChange
+}
+
+// MigrationChange is a change to a single object made by a migration.
+type MigrationChange struct {
+	// Version is the format version to which the change migrates the store.
+	Version uint64
+	// Description describes the change.
+	Description string
+	// Source is the key of the object before the change.  It is empty if the object is created.
+	Source string
+	// Target is the key of the object after the change.
+	Target string
+}
+
+// ManifestMigration records the progress of an incomplete migration.
+type ManifestMigration struct {
+	// To is the format version to which the store is being migrated.
+	To uint64 `json:"to"`
+	// LastSource is the source key of the last object migrated.  Objects are migrated in order of source key.
+	LastSource string `json:"last_source,omitempty"`
+}
+
+// migration migrates a store from the previous format version to the next.
+type migration struct {
+	// to is the format version to which the migration migrates the store.
+	to uint64
+	// description describes the migration.
+	description string
+	// plan returns the steps to migrate the store.  It must be deterministic, and only return steps that have not
+	// yet been carried out, so that an interrupted migration can be resumed.
+	plan func(s *Store, ctx context.Context) ([]*migrationStep, error)
+}
+
+// migrationStep migrates a single object.
+type migrationStep struct {
+	description string
+	source      string
+	target      string
+	// rewrite transforms the stored data of the object, for example to re-encrypt it.
+	// If nil the data is unchanged.
+	rewrite func(data []byte) ([]byte, error)
+}
+
+// migrations returns the migrations between successive format versions, in order.
+// Version 0 is a store created without a manifest by an earlier version of this module.
+func migrations() []*migration {
+	return []*migration{
+		{
+			to:          1,
+			description: "create manifest",
+			plan:        (*Store).planManifestMigration,
+		},
+	}
+}
+
+// withMigration opens the store without checking its manifest, for migration.
+func withMigration() Option {
+	return optionFunc(func(o *options) {
+		o.migrating = true
+	})
+}
+
+// Migrate upgrades a store in place to the latest format version supported by this module, rewriting objects as
+// required.  It takes the same options as New(), and returns a report of the changes made.
+// Progress is recorded in the store's manifest, so an interrupted migration is resumed by calling Migrate() again.
+// The store should not be used by other processes while it is being migrated.
+func Migrate(opts ...Option) (*MigrationReport, error) {
+	return migrate(false, opts)
+}
+
+// PlanMigration returns a report of the changes that Migrate() would make to a store, without making them.
+// It takes the same options as New(), but never creates the store's bucket or path.
+func PlanMigration(opts ...Option) (*MigrationReport, error) {
+	return migrate(true, opts)
+}
+
+func migrate(dryRun bool, opts []Option) (*MigrationReport, error) {
+	opts = append(opts, withMigration())
+	if dryRun {
+		// A plan must not write anything, including the bucket and path of a new store.
+		opts = append(opts, WithCreate(false), WithReadOnly(true))
+	}
+	store, err := New(opts...)
+	if err != nil {
+		return nil, err
+	}
+	s, isStore := store.(*Store)
+	if !isStore {
+		return nil, errors.New("unexpected store type")
+	}
+
+	ctx, span := s.startSpan(context.Background(), "Migrate")
+	report, err := s.migrate(ctx, migrations(), dryRun)
+	endSpan(span, err)
+
+	return report, err
+}
+
+// migrate carries out the given migrations that the store has yet to have.
+func (s *Store) migrate(ctx context.Context, available []*migration, dryRun bool) (*MigrationReport, error) {
+	if s.readOnly && !dryRun {
+		return nil, ErrReadOnly
+	}
+
+	manifest, err := s.readManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	version := uint64(0)
+	if manifest != nil {
+		version = manifest.Version
+	}
+	if version > manifestVersion {
+		return nil, errors.Wrap(ErrIncompatibleStore,
+			fmt.Sprintf("store format version %d is newer than the latest supported version %d", version, manifestVersion),
+		)
+	}
+
+	report := &MigrationReport{
+		FromVersion: version,
+		ToVersion:   version,
+		DryRun:      dryRun,
+		Changes:     make([]*MigrationChange, 0),
+	}
+	for _, m := range available {
+		if m.to <= version {
+			continue
+		}
+		log := s.log.With().Uint64("from", version).Uint64("to", m.to).Logger()
+
+		steps, err := m.plan(s, ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("failed to plan migration to version %d", m.to))
+		}
+		sort.Slice(steps, func(i int, j int) bool {
+			return steps[i].source < steps[j].source
+		})
+		resumeAfter := ""
+		if manifest != nil && manifest.Migration != nil && manifest.Migration.To == m.to {
+			resumeAfter = manifest.Migration.LastSource
+			log.Info().Str("last_source", resumeAfter).Msg("Resuming migration")
+		}
+
+		for _, step := range steps {
+			if step.source <= resumeAfter {
+				continue
+			}
+			report.Changes = append(report.Changes, &MigrationChange{
+				Version:     m.to,
+				Description: step.description,
+				Source:      step.source,
+				Target:      step.target,
+			})
+			if dryRun {
+				continue
+			}
+			if err := s.runMigrationStep(ctx, step); err != nil {
+				return nil, errors.Wrap(err, fmt.Sprintf("failed to migrate %s", step.source))
+			}
+			// Record progress.
+			if manifest == nil {
+				manifest = s.newManifest()
+				manifest.Version = version
+			}
+			manifest.Migration = &ManifestMigration{
+				To:         m.to,
+				LastSource: step.source,
+			}
+			if err := s.writeManifest(ctx, manifest); err != nil {
+				return nil, err
+			}
+		}
+
+		report.Changes = append(report.Changes, &MigrationChange{
+			Version:     m.to,
+			Description: m.description,
+			Target:      s.manifestPath(),
+		})
+		if !dryRun {
+			if manifest == nil {
+				manifest = s.newManifest()
+			}
+			manifest.Version = m.to
+			manifest.Migration = nil
+			if err := s.writeManifest(ctx, manifest); err != nil {
+				return nil, err
+			}
+			log.Info().Msg("Migrated store")
+		}
+		version = m.to
+		report.ToVersion = m.to
+	}
+	if !dryRun {
+		s.manifest = manifest
+	}
+
+	return report, nil
+}
+
+// runMigrationStep migrates a single object.
+func (s *Store) runMigrationStep(ctx context.Context, step *migrationStep) error {
+	data, err := s.download(ctx, step.source)
+	if err != nil {
+		return err
+	}
+	if step.rewrite != nil {
+		if data, err = step.rewrite(data); err != nil {
+			return err
+		}
+	}
+	if err := s.upload(ctx, step.target, data, s.uploadOptions(step.target)...); err != nil {
+		return err
+	}
+	if step.target != step.source {
+		if err := s.remove(ctx, step.source); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// uploadOptions returns the options with which the store uploads the object with the given key, so that migrated
+// objects have the same attributes and retention as those stored directly.
+func (s *Store) uploadOptions(key string) []uploadOption {
+	relative := key
+	if s.path != "" {
+		relative = strings.TrimPrefix(key, s.path+"/")
+	}
+	components := strings.Split(relative, "/")
+	if len(components) != 2 {
+		return nil
+	}
+	if components[0] == "pubkeys" {
+		return []uploadOption{s.withObjectAttributes(ObjectKindPubKeyIndex)}
+	}
+	if _, err := uuid.Parse(components[0]); err != nil {
+		return nil
+	}
+	switch components[1] {
+	case "index":
+		return []uploadOption{s.withObjectAttributes(ObjectKindIndex)}
+	case "batch":
+		return []uploadOption{s.withObjectAttributes(ObjectKindBatch)}
+	case components[0]:
+		return []uploadOption{s.withObjectAttributes(ObjectKindWallet), s.withObjectLock()}
+	default:
+		return []uploadOption{s.withObjectAttributes(ObjectKindAccount), s.withObjectLock()}
+	}
+}
+
+// planManifestMigration plans the migration of a store without a manifest to version 1.
+// The layout is unchanged, so no objects are rewritten, but each object is checked to ensure that the encryption
+// recorded in the new manifest, based on whether a passphrase is supplied, matches that of the existing data.
+func (s *Store) planManifestMigration(ctx context.Context) ([]*migrationStep, error) {
+	contents, err := s.listObjects(ctx, s.path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list objects")
+	}
+	for _, content := range contents {
+		key := *content.Key
+		if strings.HasSuffix(key, "/") || key == s.manifestPath() {
+			continue
+		}
+		data, err := s.download(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) == 0 || (len(data) == 2 && strings.HasSuffix(key, "/index")) {
+			// Empty data and empty indices are not encrypted.
+			continue
+		}
+		if len(s.passphrase) > 0 {
+			if _, err := s.decryptIfRequired(data); err != nil {
+				return nil, fmt.Errorf("object %s cannot be decrypted with the supplied passphrase", key)
+			}
+		} else if !json.Valid(data) {
+			return nil, fmt.Errorf("object %s is encrypted but no passphrase was supplied", key)
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,241 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateLegacyStore(t *testing.T) {
+	m, client := newMemoryS3(t)
+	passphrase := []byte("secret")
+
+	// Create a store without a manifest, as per earlier versions of this module.
+	legacy := &Store{
+		client:     client,
+		bucket:     "bucket",
+		passphrase: passphrase,
+	}
+	m.createBucket("bucket")
+	walletID := uuid.New()
+	require.NoError(t, legacy.StoreWallet(walletID, "wallet", []byte(`{"uuid":"`+walletID.String()+`","name":"wallet"}`)))
+	require.NoError(t, legacy.StoreAccountsIndex(walletID, []byte("[]")))
+	require.Nil(t, m.object("bucket", ".manifest"))
+
+	opts := []Option{WithS3Client(client), WithBucket("bucket"), WithPassphrase(passphrase)}
+
+	// Plan the migration.
+	report, err := PlanMigration(opts...)
+	require.NoError(t, err)
+	require.Equal(t, &MigrationReport{
+		FromVersion: 0,
+		ToVersion:   1,
+		DryRun:      true,
+		Changes: []*MigrationChange{
+			{Version: 1, Description: "create manifest", Target: ".manifest"},
+		},
+	}, report)
+	require.Nil(t, m.object("bucket", ".manifest"))
+
+	// Attempt the migration without the passphrase.
+	_, err = Migrate(WithS3Client(client), WithBucket("bucket"))
+	require.ErrorContains(t, err, "is encrypted but no passphrase was supplied")
+	require.Nil(t, m.object("bucket", ".manifest"))
+
+	// Migrate.
+	report, err = Migrate(opts...)
+	require.NoError(t, err)
+	require.False(t, report.DryRun)
+	require.Equal(t, uint64(1), report.ToVersion)
+
+	store, err := New(opts...)
+	require.NoError(t, err)
+	require.Equal(t, "pbkdf2", store.(*Store).Manifest().Encryption.KDF)
+	_, err = store.RetrieveWalletByID(walletID)
+	require.NoError(t, err)
+
+	// Migrating again makes no changes.
+	report, err = Migrate(opts...)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), report.FromVersion)
+	require.Empty(t, report.Changes)
+}
+
+func TestMigrateResume(t *testing.T) {
+	m, client := newMemoryS3(t)
+	for _, key := range []string{"old/a", "old/b", "old/c"} {
+		m.putObject("bucket", key, []byte(key))
+	}
+
+	failOn := "old/b"
+	testMigrations := []*migration{
+		{
+			to:          1,
+			description: "move objects",
+			plan: func(s *Store, ctx context.Context) ([]*migrationStep, error) {
+				contents, err := s.listObjects(ctx, "old/")
+				if err != nil {
+					return nil, err
+				}
+				steps := make([]*migrationStep, 0)
+				for _, content := range contents {
+					source := *content.Key
+					steps = append(steps, &migrationStep{
+						description: "move object",
+						source:      source,
+						target:      strings.Replace(source, "old/", "new/", 1),
+						rewrite: func(data []byte) ([]byte, error) {
+							if source == failOn {
+								return nil, errors.New("rewrite failed")
+							}
+
+							return bytes.ToUpper(data), nil
+						},
+					})
+				}
+
+				return steps, nil
+			},
+		},
+	}
+	store, err := New(WithS3Client(client), WithBucket("bucket"), withMigration())
+	require.NoError(t, err)
+	s := store.(*Store)
+	ctx := context.Background()
+
+	// Interrupt the migration part-way through.
+	_, err = s.migrate(ctx, testMigrations, false)
+	require.EqualError(t, err, "failed to migrate old/b: rewrite failed")
+	require.Equal(t, []byte("OLD/A"), m.object("bucket", "new/a"))
+	require.Nil(t, m.object("bucket", "old/a"))
+	manifest := &Manifest{}
+	require.NoError(t, json.Unmarshal(m.object("bucket", ".manifest"), manifest))
+	require.Equal(t, &ManifestMigration{To: 1, LastSource: "old/a"}, manifest.Migration)
+
+	// The store cannot be opened while the migration is incomplete.
+	_, err = New(WithS3Client(client), WithBucket("bucket"))
+	require.True(t, errors.Is(err, ErrMigrationInProgress))
+
+	// Plan the remainder of the migration.
+	failOn = ""
+	report, err := s.migrate(ctx, testMigrations, true)
+	require.NoError(t, err)
+	require.Equal(t, []*MigrationChange{
+		{Version: 1, Description: "move object", Source: "old/b", Target: "new/b"},
+		{Version: 1, Description: "move object", Source: "old/c", Target: "new/c"},
+		{Version: 1, Description: "move objects", Target: ".manifest"},
+	}, report.Changes)
+
+	// Resume the migration.
+	_, err = s.migrate(ctx, testMigrations, false)
+	require.NoError(t, err)
+	for _, key := range []string{"a", "b", "c"} {
+		require.Equal(t, []byte("OLD/"+strings.ToUpper(key)), m.object("bucket", "new/"+key))
+		require.Nil(t, m.object("bucket", "old/"+key))
+	}
+	manifest = &Manifest{}
+	require.NoError(t, json.Unmarshal(m.object("bucket", ".manifest"), manifest))
+	require.Nil(t, manifest.Migration)
+	require.Equal(t, uint64(1), manifest.Version)
+}
+
+func TestMigrateReadOnly(t *testing.T) {
+	_, client := newMemoryS3(t)
+	_, err := New(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+
+	_, err = Migrate(WithS3Client(client), WithBucket("bucket"), WithReadOnly(true))
+	require.Equal(t, ErrReadOnly, err)
+}
+
+func TestPlanMigrationWritesNothing(t *testing.T) {
+	m, client := newMemoryS3(t)
+	m.createBucket("bucket")
+
+	// An empty bucket is planned as a new store, but is left untouched.
+	report, err := PlanMigration(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+	require.True(t, report.DryRun)
+	require.Equal(t, []*MigrationChange{
+		{Version: 1, Description: "create manifest", Target: ".manifest"},
+	}, report.Changes)
+	keys, _ := m.keys("bucket")
+	require.Empty(t, keys)
+
+	// A store path is not created.
+	_, err = PlanMigration(WithS3Client(client), WithBucket("bucket"), WithPath("store"))
+	var notFoundErr *NotFoundError
+	require.ErrorAs(t, err, &notFoundErr)
+	keys, _ = m.keys("bucket")
+	require.Empty(t, keys)
+
+	// A bucket is not created.
+	_, err = PlanMigration(WithS3Client(client), WithBucket("missing"))
+	require.ErrorAs(t, err, &notFoundErr)
+	_, exists := m.keys("missing")
+	require.False(t, exists)
+}
+
+func TestMigrationUploadOptions(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client),
+		WithBucket("bucket"),
+		WithPath("store"),
+		WithObjectAttributes(ObjectKindAccount, ObjectAttributes{StorageClass: "STANDARD_IA"}),
+		WithObjectLock(ObjectLock{Mode: ObjectLockModeGovernance, Retention: time.Hour}),
+	)
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	accountID := uuid.New()
+	tests := []struct {
+		name   string
+		key    string
+		kind   string
+		locked bool
+	}{
+		{name: "Wallet", key: s.walletHeaderPath(walletID), kind: "wallet", locked: true},
+		{name: "Account", key: s.accountPath(walletID, accountID), kind: "account", locked: true},
+		{name: "Index", key: s.walletIndexPath(walletID), kind: "index"},
+		{name: "Batch", key: s.walletBatchPath(walletID), kind: "batch"},
+		{name: "PubKeyIndex", key: s.pubKeyIndexPath([]byte{0x01}), kind: "pubkey-index"},
+		{name: "NameMarker", key: s.accountNamePath(walletID, "name")},
+		{name: "Manifest", key: s.manifestPath()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := "old/" + test.key
+			m.putObject("bucket", source, []byte(`{"test":true}`))
+			require.NoError(t, s.runMigrationStep(context.Background(), &migrationStep{source: source, target: test.key}))
+
+			header := m.header("bucket", test.key)
+			require.Equal(t, test.kind, header.Get("X-Amz-Meta-Object-Kind"))
+			require.Equal(t, test.locked, header.Get("X-Amz-Object-Lock-Mode") != "")
+			if test.kind == "account" {
+				require.Equal(t, "STANDARD_IA", header.Get("X-Amz-Storage-Class"))
+			}
+		})
+	}
+}
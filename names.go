@@ -0,0 +1,308 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// ErrDuplicateName is returned, wrapped in a *DuplicateNameError, when a name is already in use.
+var ErrDuplicateName = errors.New("name already in use")
+
+// DuplicateNameError is returned when an attempt is made to store an item with a name that is already used by a
+// different item.
+type DuplicateNameError struct {
+	// Kind is the kind of the item.
+	Kind ObjectKind
+	// Name is the name of the item.
+	Name string
+	// ExistingID is the ID of the item already using the name.
+	ExistingID uuid.UUID
+}
+
+// Error implements the error interface.
+func (e *DuplicateNameError) Error() string {
+	return fmt.Sprintf("%s name %q already used by %s", e.Kind, e.Name, e.ExistingID)
+}
+
+// Unwrap returns ErrDuplicateName.
+func (e *DuplicateNameError) Unwrap() error {
+	return ErrDuplicateName
+}
+
+// DuplicateWalletsError is returned when more than one wallet with the same name is found.
+type DuplicateWalletsError struct {
+	// Name is the name of the wallets.
+	Name string
+	// IDs are the IDs of the wallets, in order.
+	IDs []uuid.UUID
+}
+
+// Error implements the error interface.
+func (e *DuplicateWalletsError) Error() string {
+	ids := make([]string, len(e.IDs))
+	for i := range e.IDs {
+		ids[i] = e.IDs[i].String()
+	}
+
+	return fmt.Sprintf("wallet name %q used by multiple wallets: %s", e.Name, strings.Join(ids, ", "))
+}
+
+// Unwrap returns ErrDuplicateName.
+func (e *DuplicateWalletsError) Unwrap() error {
+	return ErrDuplicateName
+}
+
+// nameMarkerGracePeriod is the time for which the item recorded in a name marker is taken to use the name even if it
+// does not exist, as the marker is written before the item.  It is longer than any single store operation.
+const nameMarkerGracePeriod = 15 * time.Minute
+
+// nameMarker is the content of a name marker, which records the item using a name.
+type nameMarker struct {
+	ID uuid.UUID `json:"uuid"`
+}
+
+// itemName returns the ID and name of an item from its data.
+func itemName(data []byte) (uuid.UUID, string, error) {
+	info := &struct {
+		ID   uuid.UUID `json:"uuid"`
+		Name string    `json:"name"`
+	}{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return uuid.Nil, "", errors.Wrap(err, "failed to parse data")
+	}
+
+	return info.ID, info.Name, nil
+}
+
+// claimWalletName claims a name for a wallet, returning a *DuplicateNameError if another wallet already has the
+// name.  It returns true if the name marker was written.
+func (s *Store) claimWalletName(ctx context.Context, walletID uuid.UUID, name string) (bool, error) {
+	key := s.walletNamePath(name)
+	claimed, err := s.claimName(ctx, key, ObjectKindWallet, walletID, name, s.walletHasName(name))
+	if err != nil || !claimed {
+		return claimed, err
+	}
+
+	// Stores written by earlier versions of this module do not have name markers, so existing wallets are checked.
+	for data := range s.retrieveWallets(ctx) {
+		existingID, existingName, err := itemName(data)
+		if err != nil || existingName != name || existingID == walletID {
+			continue
+		}
+		// Record the existing wallet in the marker, so that it is found directly in future.
+		if err := s.recordName(ctx, key, existingID); err != nil {
+			return false, err
+		}
+
+		return false, &DuplicateNameError{Kind: ObjectKindWallet, Name: name, ExistingID: existingID}
+	}
+
+	return true, nil
+}
+
+// walletHasName returns a function that checks if a wallet has the given name, returning missing if the wallet does
+// not exist.
+func (s *Store) walletHasName(name string) func(ctx context.Context, id uuid.UUID, missing bool) (bool, error) {
+	return func(ctx context.Context, id uuid.UUID, missing bool) (bool, error) {
+		key := s.walletHeaderPath(id)
+		data, err := s.download(ctx, key)
+		if err != nil {
+			if isKeyNotFound(err) {
+				return missing, nil
+			}
+
+			return false, err
+		}
+		data, err = s.decrypt(ctx, key, data)
+		if err != nil {
+			return false, err
+		}
+		_, walletName, err := itemName(data)
+		if err != nil {
+			return false, err
+		}
+
+		return walletName == name, nil
+	}
+}
+
+// claimAccountName claims a name for an account in a wallet, returning a *DuplicateNameError if another account
+// in the wallet already has the name.  It returns true if the name marker was written.
+func (s *Store) claimAccountName(ctx context.Context, walletID uuid.UUID, accountID uuid.UUID, name string) (bool, error) {
+	// Stores without name markers rely on the account index.
+	index, err := s.walletIndex(ctx, walletID)
+	if err != nil {
+		return false, err
+	}
+	if existingID, exists := index.ID(name); exists && existingID != accountID {
+		owned, err := s.accountHasName(walletID, name)(ctx, existingID, false)
+		if err != nil {
+			return false, err
+		}
+		if owned {
+			return false, &DuplicateNameError{Kind: ObjectKindAccount, Name: name, ExistingID: existingID}
+		}
+	}
+
+	return s.claimName(ctx, s.accountNamePath(walletID, name), ObjectKindAccount, accountID, name,
+		s.accountHasName(walletID, name))
+}
+
+// accountHasName returns a function that checks if an account in a wallet has the given name, returning missing if
+// the account does not exist.
+func (s *Store) accountHasName(walletID uuid.UUID,
+	name string,
+) func(ctx context.Context, id uuid.UUID, missing bool) (bool, error) {
+	return func(ctx context.Context, id uuid.UUID, missing bool) (bool, error) {
+		data, err := s.retrieveAccount(ctx, walletID, id)
+		if err != nil {
+			if isKeyNotFound(err) {
+				return missing, nil
+			}
+
+			return false, err
+		}
+		_, accountName, err := itemName(data)
+		if err != nil {
+			return false, err
+		}
+
+		return accountName == name, nil
+	}
+}
+
+// claimName claims a name by writing a marker holding the ID of the item using it.  The marker is written only if it
+// does not already exist, so that of concurrent writers only one can claim the name.  This relies on conditional
+// writes; for services without them concurrent writers can both claim the name, as per putThenVerify().
+// If the marker exists but the item it records no longer uses the name, as checked by owns, the marker is taken over.
+// It returns true if the marker was written, in which case a writer that then fails to store the item should release
+// the name with releaseName().
+func (s *Store) claimName(ctx context.Context,
+	key string,
+	kind ObjectKind,
+	id uuid.UUID,
+	name string,
+	owns func(ctx context.Context, id uuid.UUID, missing bool) (bool, error),
+) (
+	bool,
+	error,
+) {
+	data, err := s.nameMarkerData(id)
+	if err != nil {
+		return false, err
+	}
+
+	created, err := s.putIfAbsent(ctx, key, data)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to store name marker")
+	}
+	if created {
+		return true, nil
+	}
+
+	marker, info, err := s.retrieveNameMarker(ctx, key)
+	if err != nil {
+		return false, err
+	}
+	if marker.ID == id {
+		return false, nil
+	}
+
+	// The item recorded in a recent marker may not exist yet, as the marker is written first.  Once the marker is
+	// older than the grace period a missing item is taken to have failed to be stored.
+	owned, err := owns(ctx, marker.ID, info.age < nameMarkerGracePeriod)
+	if err != nil {
+		return false, err
+	}
+	if owned {
+		return false, &DuplicateNameError{Kind: kind, Name: name, ExistingID: marker.ID}
+	}
+
+	// The marker is stale, for example because the item has been renamed.  It is only taken over if it has not
+	// changed since it was read, so that of concurrent writers only one can take it over.
+	updated, err := s.putIfMatch(ctx, key, data, info.etag)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to store name marker")
+	}
+	if !updated {
+		marker, _, err := s.retrieveNameMarker(ctx, key)
+		if err != nil {
+			return false, err
+		}
+
+		return false, &DuplicateNameError{Kind: kind, Name: name, ExistingID: marker.ID}
+	}
+
+	return true, nil
+}
+
+// retrieveNameMarker retrieves the name marker with the given key, returning it and information about its object.
+func (s *Store) retrieveNameMarker(ctx context.Context, key string) (*nameMarker, *objectInfo, error) {
+	data, info, err := s.downloadWithInfo(ctx, key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to retrieve name marker")
+	}
+	data, err = s.decrypt(ctx, key, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	marker := &nameMarker{}
+	if err := json.Unmarshal(data, marker); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse name marker")
+	}
+
+	return marker, info, nil
+}
+
+// nameMarkerData returns the data of a name marker recording the item with the given ID.
+func (s *Store) nameMarkerData(id uuid.UUID) ([]byte, error) {
+	data, err := json.Marshal(&nameMarker{ID: id})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create name marker")
+	}
+	data, err = s.encryptIfRequired(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encrypt name marker")
+	}
+
+	return data, nil
+}
+
+// recordName unconditionally writes a name marker recording the item with the given ID.
+func (s *Store) recordName(ctx context.Context, key string, id uuid.UUID) error {
+	data, err := s.nameMarkerData(id)
+	if err != nil {
+		return err
+	}
+	if err := s.upload(ctx, key, data); err != nil {
+		return errors.Wrap(err, "failed to store name marker")
+	}
+
+	return nil
+}
+
+// releaseName removes a name marker claimed for an item that could not be stored.
+func (s *Store) releaseName(ctx context.Context, key string) {
+	if err := s.remove(ctx, key); err != nil {
+		s.log.Warn().Str("key", key).Str("code", errorCode(err)).Err(err).Msg("Failed to release name")
+	}
+}
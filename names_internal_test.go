@@ -0,0 +1,361 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountNameUniqueness(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithPassphrase([]byte("secret")))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+	account := func(accountID uuid.UUID, name string) []byte {
+		return []byte(fmt.Sprintf(`{"uuid":%q,"name":%q}`, accountID, name))
+	}
+
+	// The marker is encrypted.
+	accountID := uuid.New()
+	require.NoError(t, s.StoreAccount(walletID, accountID, account(accountID, "validator-1")))
+	require.NotContains(t, string(m.object("bucket", s.accountNamePath(walletID, "validator-1"))), accountID.String())
+
+	// The same account can be stored again.
+	require.NoError(t, s.StoreAccount(walletID, accountID, account(accountID, "validator-1")))
+
+	// A different account cannot use the name.
+	otherID := uuid.New()
+	err = s.StoreAccount(walletID, otherID, account(otherID, "validator-1"))
+	require.True(t, errors.Is(err, ErrDuplicateName))
+	var duplicateErr *DuplicateNameError
+	require.ErrorAs(t, err, &duplicateErr)
+	require.Equal(t, ObjectKindAccount, duplicateErr.Kind)
+	require.Equal(t, accountID, duplicateErr.ExistingID)
+
+	// The name is available in another wallet.
+	otherWalletID := uuid.New()
+	require.NoError(t, s.StoreWallet(otherWalletID, "other", []byte(fmt.Sprintf(`{"uuid":%q,"name":"other"}`, otherWalletID))))
+	require.NoError(t, s.StoreAccount(otherWalletID, otherID, account(otherID, "validator-1")))
+
+	// Renaming the account releases its name.
+	require.NoError(t, s.StoreAccount(walletID, accountID, account(accountID, "validator-2")))
+	require.NoError(t, s.StoreAccount(walletID, otherID, account(otherID, "validator-1")))
+	thirdID := uuid.New()
+	require.ErrorIs(t, s.StoreAccount(walletID, thirdID, account(thirdID, "validator-2")), ErrDuplicateName)
+}
+
+func TestAccountNameIndex(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+
+	// An account written by an earlier version of the module, without a name marker.
+	accountID := uuid.New()
+	m.putObject("bucket", s.accountPath(walletID, accountID), []byte(fmt.Sprintf(`{"uuid":%q,"name":"legacy"}`, accountID)))
+	require.NoError(t, s.StoreAccountsIndex(walletID, []byte(fmt.Sprintf(`[{"uuid":%q,"name":"legacy"}]`, accountID))))
+
+	otherID := uuid.New()
+	err = s.StoreAccount(walletID, otherID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"legacy"}`, otherID)))
+	require.ErrorIs(t, err, ErrDuplicateName)
+}
+
+func TestAccountNameConcurrent(t *testing.T) {
+	_, client := newMemoryS3(t)
+	// Names are only unique under concurrent writers with a provider that supports conditional writes.
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithProvider(ProviderMinIO))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+
+	// Concurrent writers of different accounts with the same name; only one can succeed.
+	errs := make([]error, 8)
+	wg := sync.WaitGroup{}
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			accountID := uuid.New()
+			errs[i] = s.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"contested"}`, accountID)))
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			require.ErrorIs(t, err, ErrDuplicateName)
+		}
+	}
+	require.Equal(t, 1, succeeded)
+}
+
+func TestWalletNameUniqueness(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithPassphrase([]byte("secret")))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	wallet := func(walletID uuid.UUID, name string) []byte {
+		return []byte(fmt.Sprintf(`{"uuid":%q,"name":%q}`, walletID, name))
+	}
+
+	// The marker is encrypted.
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet-1", wallet(walletID, "wallet-1")))
+	require.NotContains(t, string(m.object("bucket", s.walletNamePath("wallet-1"))), walletID.String())
+
+	// The same wallet can be stored again.
+	require.NoError(t, s.StoreWallet(walletID, "wallet-1", wallet(walletID, "wallet-1")))
+
+	// A different wallet cannot use the name.
+	otherID := uuid.New()
+	err = s.StoreWallet(otherID, "wallet-1", wallet(otherID, "wallet-1"))
+	var duplicateErr *DuplicateNameError
+	require.ErrorAs(t, err, &duplicateErr)
+	require.Equal(t, ObjectKindWallet, duplicateErr.Kind)
+	require.Equal(t, walletID, duplicateErr.ExistingID)
+
+	// The name must match that in the data.
+	require.EqualError(t, s.StoreWallet(otherID, "wallet-2", wallet(otherID, "wallet-3")), "wallet name does not match data")
+
+	// Renaming the wallet releases its name.
+	require.NoError(t, s.StoreWallet(walletID, "wallet-2", wallet(walletID, "wallet-2")))
+	require.NoError(t, s.StoreWallet(otherID, "wallet-1", wallet(otherID, "wallet-1")))
+	data, err := s.RetrieveWallet("wallet-1")
+	require.NoError(t, err)
+	require.Equal(t, wallet(otherID, "wallet-1"), data)
+}
+
+func TestWalletNameLegacy(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	// Wallets written by an earlier version of the module, without name markers.
+	walletIDs := []uuid.UUID{uuid.New(), uuid.New()}
+	for _, walletID := range walletIDs {
+		m.putObject("bucket", s.walletHeaderPath(walletID), []byte(fmt.Sprintf(`{"uuid":%q,"name":"legacy"}`, walletID)))
+	}
+
+	// Duplicates are reported regardless of the order in which wallets are retrieved.
+	_, err = s.RetrieveWallet("legacy")
+	require.ErrorIs(t, err, ErrDuplicateName)
+	var duplicatesErr *DuplicateWalletsError
+	require.ErrorAs(t, err, &duplicatesErr)
+	require.Equal(t, "legacy", duplicatesErr.Name)
+	require.ElementsMatch(t, walletIDs, duplicatesErr.IDs)
+
+	// A new wallet cannot use the name of an existing wallet.
+	otherID := uuid.New()
+	err = s.StoreWallet(otherID, "legacy", []byte(fmt.Sprintf(`{"uuid":%q,"name":"legacy"}`, otherID)))
+	var duplicateErr *DuplicateNameError
+	require.ErrorAs(t, err, &duplicateErr)
+	_, err = s.RetrieveWalletByID(otherID)
+	require.Error(t, err)
+
+	// The name now belongs to one of the existing wallets, so the other must be renamed to resolve the duplicate.
+	ownerID, renamedID := walletIDs[0], walletIDs[1]
+	if duplicateErr.ExistingID != ownerID {
+		ownerID, renamedID = renamedID, ownerID
+	}
+	require.NoError(t, s.StoreWallet(ownerID, "legacy", []byte(fmt.Sprintf(`{"uuid":%q,"name":"legacy"}`, ownerID))))
+	require.ErrorIs(t, s.StoreWallet(renamedID, "legacy", []byte(fmt.Sprintf(`{"uuid":%q,"name":"legacy"}`, renamedID))), ErrDuplicateName)
+	require.NoError(t, s.StoreWallet(renamedID, "renamed", []byte(fmt.Sprintf(`{"uuid":%q,"name":"renamed"}`, renamedID))))
+	data, err := s.RetrieveWallet("legacy")
+	require.NoError(t, err)
+	require.Contains(t, string(data), ownerID.String())
+}
+
+func TestWalletNameConcurrent(t *testing.T) {
+	_, client := newMemoryS3(t)
+	// Names are only unique under concurrent writers with a provider that supports conditional writes.
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithProvider(ProviderMinIO))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	// Concurrent writers of different wallets with the same name; only one can succeed.
+	errs := make([]error, 8)
+	wg := sync.WaitGroup{}
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			walletID := uuid.New()
+			errs[i] = s.StoreWallet(walletID, "contested", []byte(fmt.Sprintf(`{"uuid":%q,"name":"contested"}`, walletID)))
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			require.ErrorIs(t, err, ErrDuplicateName)
+		}
+	}
+	require.Equal(t, 1, succeeded)
+	_, err = s.RetrieveWallet("contested")
+	require.NoError(t, err)
+}
+
+func TestClaimNameWithoutConditionalWrites(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+	s := store.(*Store)
+	s.providerProfile = providerProfiles()[providerGeneric]
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+	accountID := uuid.New()
+	require.NoError(t, s.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"account"}`, accountID))))
+
+	// The marker is written without a condition.
+	key := s.accountNamePath(walletID, "account")
+	require.NotNil(t, m.object("bucket", key))
+	require.Empty(t, m.header("bucket", key).Get("If-None-Match"))
+
+	// The name is still unique.
+	otherID := uuid.New()
+	err = s.StoreAccount(walletID, otherID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"account"}`, otherID)))
+	require.ErrorIs(t, err, ErrDuplicateName)
+
+	// An existing object is not overwritten.
+	created, err := s.putIfAbsent(context.Background(), key, []byte("other"))
+	require.NoError(t, err)
+	require.False(t, created)
+	require.NotEqual(t, []byte("other"), m.object("bucket", key))
+}
+
+func TestClaimNameTakeoverConflict(t *testing.T) {
+	_, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithProvider(ProviderMinIO))
+	require.NoError(t, err)
+	s := store.(*Store)
+	ctx := context.Background()
+
+	walletID := uuid.New()
+	key := s.accountNamePath(walletID, "name")
+	staleID := uuid.New()
+	require.NoError(t, s.recordName(ctx, key, staleID))
+
+	// Another writer takes over the stale marker after it has been read.
+	otherID := uuid.New()
+	id := uuid.New()
+	_, err = s.claimName(ctx, key, ObjectKindAccount, id, "name", func(ctx context.Context, _ uuid.UUID, _ bool) (bool, error) {
+		require.NoError(t, s.recordName(ctx, key, otherID))
+
+		return false, nil
+	})
+	var duplicateErr *DuplicateNameError
+	require.ErrorAs(t, err, &duplicateErr)
+	require.Equal(t, otherID, duplicateErr.ExistingID)
+	marker, _, err := s.retrieveNameMarker(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, otherID, marker.ID)
+
+	// With no other writer the stale marker is taken over.
+	claimed, err := s.claimName(ctx, key, ObjectKindAccount, id, "name", func(context.Context, uuid.UUID, bool) (bool, error) {
+		return false, nil
+	})
+	require.NoError(t, err)
+	require.True(t, claimed)
+	marker, _, err = s.retrieveNameMarker(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, id, marker.ID)
+}
+
+func TestAccountNameOrphaned(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+	s := store.(*Store)
+	ctx := context.Background()
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+
+	// A writer claims the name but fails before storing its account.
+	orphanID := uuid.New()
+	claimed, err := s.claimAccountName(ctx, walletID, orphanID, "orphaned")
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	// Until the grace period has passed the account may still be being stored, so the name is in use.
+	accountID := uuid.New()
+	data := []byte(fmt.Sprintf(`{"uuid":%q,"name":"orphaned"}`, accountID))
+	err = s.StoreAccount(walletID, accountID, data)
+	var duplicateErr *DuplicateNameError
+	require.ErrorAs(t, err, &duplicateErr)
+	require.Equal(t, orphanID, duplicateErr.ExistingID)
+
+	// After the grace period the marker is taken over.
+	m.advanceClock(nameMarkerGracePeriod)
+	require.NoError(t, s.StoreAccount(walletID, accountID, data))
+	marker, _, err := s.retrieveNameMarker(ctx, s.accountNamePath(walletID, "orphaned"))
+	require.NoError(t, err)
+	require.Equal(t, accountID, marker.ID)
+}
+
+func TestWalletNameOrphaned(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+	s := store.(*Store)
+	ctx := context.Background()
+
+	// A writer claims the name but fails before storing its wallet.
+	orphanID := uuid.New()
+	claimed, err := s.claimWalletName(ctx, orphanID, "orphaned")
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	// Until the grace period has passed the wallet may still be being stored, so the name is in use.
+	walletID := uuid.New()
+	data := []byte(fmt.Sprintf(`{"uuid":%q,"name":"orphaned"}`, walletID))
+	err = s.StoreWallet(walletID, "orphaned", data)
+	var duplicateErr *DuplicateNameError
+	require.ErrorAs(t, err, &duplicateErr)
+	require.Equal(t, orphanID, duplicateErr.ExistingID)
+
+	// After the grace period the marker is taken over.
+	m.advanceClock(nameMarkerGracePeriod)
+	require.NoError(t, s.StoreWallet(walletID, "orphaned", data))
+	marker, _, err := s.retrieveNameMarker(ctx, s.walletNamePath("orphaned"))
+	require.NoError(t, err)
+	require.Equal(t, walletID, marker.ID)
+	stored, err := s.RetrieveWallet("orphaned")
+	require.NoError(t, err)
+	require.Equal(t, data, stored)
+}
@@ -0,0 +1,266 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ObjectLockMode is the S3 Object Lock retention mode.
+type ObjectLockMode string
+
+const (
+	// ObjectLockModeNone applies no retention.
+	ObjectLockModeNone ObjectLockMode = ""
+	// ObjectLockModeGovernance prevents deletion or overwriting of a version during its retention period, except by
+	// users with permission to bypass governance retention.
+	ObjectLockModeGovernance ObjectLockMode = s3.ObjectLockModeGovernance
+	// ObjectLockModeCompliance prevents deletion or overwriting of a version during its retention period by any user,
+	// and prevents the retention period from being shortened.
+	ObjectLockModeCompliance ObjectLockMode = s3.ObjectLockModeCompliance
+)
+
+// ObjectLock defines the S3 Object Lock settings applied to wallet and account objects when they are written.
+// The store's bucket must have Object Lock enabled; see BucketProvisioning.
+type ObjectLock struct {
+	// Mode is the retention mode.  If ObjectLockModeNone no retention is applied.
+	Mode ObjectLockMode
+	// Retention is the period from when an object is written for which it is retained.
+	Retention time.Duration
+	// LegalHold places a legal hold on objects, which retains them until the hold is removed.
+	LegalHold bool
+}
+
+// ObjectRetention is the S3 Object Lock retention of an object.
+type ObjectRetention struct {
+	// Mode is the retention mode, or ObjectLockModeNone if the object has no retention.
+	Mode ObjectLockMode
+	// RetainUntil is the time until which the object is retained.
+	RetainUntil time.Time
+	// LegalHold is true if the object is subject to a legal hold.
+	LegalHold bool
+}
+
+// WithObjectLock sets the S3 Object Lock settings applied to wallet and account objects when they are written.
+// If not supplied objects are written without retention or legal holds, although the default retention of the
+// bucket still applies.
+func WithObjectLock(t ObjectLock) Option {
+	return optionFunc(func(o *options) {
+		o.objectLock = &t
+	})
+}
+
+// checkObjectLock checks that object lock settings are valid.
+func checkObjectLock(lock *ObjectLock) error {
+	switch lock.Mode {
+	case ObjectLockModeNone:
+		if lock.Retention != 0 {
+			return errors.New("object lock retention requires a mode")
+		}
+	case ObjectLockModeGovernance, ObjectLockModeCompliance:
+		if lock.Retention <= 0 {
+			return errors.New("object lock mode requires a retention period")
+		}
+	default:
+		return fmt.Errorf("unsupported object lock mode %q", lock.Mode)
+	}
+
+	return nil
+}
+
+// withObjectLock is an upload option that applies the store's object lock settings.
+func (s *Store) withObjectLock() uploadOption {
+	return func(input *s3manager.UploadInput, data []byte) {
+		if s.objectLock == nil {
+			return
+		}
+		if s.objectLock.Mode != ObjectLockModeNone {
+			input.ObjectLockMode = aws.String(string(s.objectLock.Mode))
+			input.ObjectLockRetainUntilDate = aws.Time(time.Now().Add(s.objectLock.Retention))
+		}
+		if s.objectLock.LegalHold {
+			input.ObjectLockLegalHoldStatus = aws.String(s3.ObjectLockLegalHoldStatusOn)
+		}
+		// Writes with object lock settings must supply an MD5 checksum, regardless of the provider's defaults.
+		sum := md5.Sum(data) //nolint:gosec
+		input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	}
+}
+
+// AccountRetention returns the S3 Object Lock retention of the current version of an account.
+func (s *Store) AccountRetention(walletID uuid.UUID, accountID uuid.UUID) (*ObjectRetention, error) {
+	ctx, span := s.startSpan(context.Background(), "AccountRetention",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("account_id", accountID.String()),
+	)
+	retention, err := s.retention(ctx, s.accountPath(walletID, accountID))
+	endSpan(span, err)
+
+	return retention, err
+}
+
+// ExtendAccountRetention extends the S3 Object Lock retention of the current version of an account until the given
+// time.  If the account has no retention the store's object lock mode is used.
+func (s *Store) ExtendAccountRetention(walletID uuid.UUID, accountID uuid.UUID, until time.Time) error {
+	ctx, span := s.startSpan(context.Background(), "ExtendAccountRetention",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("account_id", accountID.String()),
+	)
+	err := s.extendRetention(ctx, s.accountPath(walletID, accountID), until)
+	endSpan(span, err)
+
+	return err
+}
+
+// SetAccountLegalHold places or removes a legal hold on the current version of an account.
+func (s *Store) SetAccountLegalHold(walletID uuid.UUID, accountID uuid.UUID, hold bool) error {
+	ctx, span := s.startSpan(context.Background(), "SetAccountLegalHold",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("account_id", accountID.String()),
+	)
+	err := s.setLegalHold(ctx, s.accountPath(walletID, accountID), hold)
+	endSpan(span, err)
+
+	return err
+}
+
+// retention returns the retention of the object with the given key.
+func (s *Store) retention(ctx context.Context, key string) (*ObjectRetention, error) {
+	ctx, span := s.startSpan(ctx, "GetObjectRetention",
+		attribute.String("aws.s3.bucket", s.bucket),
+		attribute.String("aws.s3.key", key),
+	)
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+
+	retention := &ObjectRetention{}
+	resp, err := s.client.GetObjectRetentionWithContext(ctx, &s3.GetObjectRetentionInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s.requestOptions(span, key)...)
+	switch {
+	case err == nil:
+		if resp.Retention != nil {
+			retention.Mode = ObjectLockMode(aws.StringValue(resp.Retention.Mode))
+			retention.RetainUntil = aws.TimeValue(resp.Retention.RetainUntilDate)
+		}
+	case isNoObjectLockConfiguration(err):
+	default:
+		endSpan(span, err)
+		return nil, errors.Wrap(err, "failed to obtain retention")
+	}
+
+	holdResp, err := s.client.GetObjectLegalHoldWithContext(ctx, &s3.GetObjectLegalHoldInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s.requestOptions(span, key)...)
+	switch {
+	case err == nil:
+		if holdResp.LegalHold != nil {
+			retention.LegalHold = aws.StringValue(holdResp.LegalHold.Status) == s3.ObjectLockLegalHoldStatusOn
+		}
+	case isNoObjectLockConfiguration(err):
+	default:
+		endSpan(span, err)
+		return nil, errors.Wrap(err, "failed to obtain legal hold")
+	}
+	endSpan(span, nil)
+
+	return retention, nil
+}
+
+// extendRetention extends the retention of the object with the given key.
+func (s *Store) extendRetention(ctx context.Context, key string, until time.Time) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	current, err := s.retention(ctx, key)
+	if err != nil {
+		return err
+	}
+	mode := current.Mode
+	if mode == ObjectLockModeNone && s.objectLock != nil {
+		mode = s.objectLock.Mode
+	}
+	if mode == ObjectLockModeNone {
+		return errors.New("no object lock mode for retention")
+	}
+	if until.Before(current.RetainUntil) {
+		return errors.New("retention can only be extended")
+	}
+
+	ctx, span := s.startSpan(ctx, "PutObjectRetention",
+		attribute.String("aws.s3.bucket", s.bucket),
+		attribute.String("aws.s3.key", key),
+	)
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+	_, err = s.client.PutObjectRetentionWithContext(ctx, &s3.PutObjectRetentionInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Retention: &s3.ObjectLockRetention{
+			Mode:            aws.String(string(mode)),
+			RetainUntilDate: aws.Time(until),
+		},
+	}, s.requestOptions(span, key)...)
+	endSpan(span, err)
+	if err != nil {
+		return errors.Wrap(err, "failed to extend retention")
+	}
+
+	return nil
+}
+
+// setLegalHold places or removes a legal hold on the object with the given key.
+func (s *Store) setLegalHold(ctx context.Context, key string, hold bool) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	ctx, span := s.startSpan(ctx, "PutObjectLegalHold",
+		attribute.String("aws.s3.bucket", s.bucket),
+		attribute.String("aws.s3.key", key),
+	)
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+	status := s3.ObjectLockLegalHoldStatusOff
+	if hold {
+		status = s3.ObjectLockLegalHoldStatusOn
+	}
+	_, err := s.client.PutObjectLegalHoldWithContext(ctx, &s3.PutObjectLegalHoldInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		LegalHold: &s3.ObjectLockLegalHold{
+			Status: aws.String(status),
+		},
+	}, s.requestOptions(span, key)...)
+	endSpan(span, err)
+	if err != nil {
+		return errors.Wrap(err, "failed to set legal hold")
+	}
+
+	return nil
+}
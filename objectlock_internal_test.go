@@ -0,0 +1,131 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckObjectLock(t *testing.T) {
+	tests := []struct {
+		name string
+		lock ObjectLock
+		err  string
+	}{
+		{
+			name: "Empty",
+		},
+		{
+			name: "LegalHold",
+			lock: ObjectLock{LegalHold: true},
+		},
+		{
+			name: "Governance",
+			lock: ObjectLock{Mode: ObjectLockModeGovernance, Retention: time.Hour},
+		},
+		{
+			name: "RetentionWithoutMode",
+			lock: ObjectLock{Retention: time.Hour},
+			err:  "object lock retention requires a mode",
+		},
+		{
+			name: "ModeWithoutRetention",
+			lock: ObjectLock{Mode: ObjectLockModeCompliance},
+			err:  "object lock mode requires a retention period",
+		},
+		{
+			name: "InvalidMode",
+			lock: ObjectLock{Mode: "forever", Retention: time.Hour},
+			err:  `unsupported object lock mode "forever"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkObjectLock(&test.lock)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestObjectLock(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithObjectLock(ObjectLock{
+		Mode:      ObjectLockModeCompliance,
+		Retention: 24 * time.Hour,
+		LegalHold: true,
+	}))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	accountID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+	require.NoError(t, s.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"account"}`, accountID))))
+	require.NoError(t, s.StoreAccountsIndex(walletID, []byte("[]")))
+
+	for _, key := range []string{s.walletHeaderPath(walletID), s.accountPath(walletID, accountID)} {
+		header := m.header("bucket", key)
+		require.Equal(t, "COMPLIANCE", header.Get("x-amz-object-lock-mode"))
+		require.Equal(t, "ON", header.Get("x-amz-object-lock-legal-hold"))
+		require.NotEmpty(t, header.Get("Content-MD5"))
+	}
+	// Indices are not locked.
+	require.Empty(t, m.header("bucket", s.walletIndexPath(walletID)).Get("x-amz-object-lock-mode"))
+
+	retention, err := s.AccountRetention(walletID, accountID)
+	require.NoError(t, err)
+	require.Equal(t, ObjectLockModeCompliance, retention.Mode)
+	require.True(t, retention.LegalHold)
+	require.WithinDuration(t, time.Now().Add(24*time.Hour), retention.RetainUntil, time.Minute)
+
+	require.EqualError(t, s.ExtendAccountRetention(walletID, accountID, time.Now()), "retention can only be extended")
+	until := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	require.NoError(t, s.ExtendAccountRetention(walletID, accountID, until))
+	require.NoError(t, s.SetAccountLegalHold(walletID, accountID, false))
+
+	retention, err = s.AccountRetention(walletID, accountID)
+	require.NoError(t, err)
+	require.Equal(t, ObjectLockModeCompliance, retention.Mode)
+	require.False(t, retention.LegalHold)
+	require.True(t, until.Equal(retention.RetainUntil))
+}
+
+func TestObjectLockUnlocked(t *testing.T) {
+	_, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	accountID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+	require.NoError(t, s.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"account"}`, accountID))))
+
+	retention, err := s.AccountRetention(walletID, accountID)
+	require.NoError(t, err)
+	require.Equal(t, &ObjectRetention{}, retention)
+
+	require.EqualError(t, s.ExtendAccountRetention(walletID, accountID, time.Now().Add(time.Hour)),
+		"no object lock mode for retention")
+}
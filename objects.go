@@ -0,0 +1,391 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// requestOptions returns the options applied to each S3 request for the given key.
+func (s *Store) requestOptions(span trace.Span, key string) []request.Option {
+	opts := []request.Option{
+		withRequestIDs(span),
+		withRetryLogging(s.log.With().Str("key", key).Logger()),
+	}
+	if s.retryer != nil {
+		opts = append(opts, withRetryer(s.retryer))
+	}
+	if s.limiter != nil {
+		opts = append(opts, withThrottleDetection(s.limiter))
+	}
+	if s.providerProfile != nil {
+		opts = append(opts, withProviderCompatibility(s.providerProfile))
+	}
+	if s.readOnly {
+		opts = append(opts, withReadOnlyGuard())
+	}
+
+	return opts
+}
+
+// listObjects lists all objects in the store's bucket with the given prefix.
+func (s *Store) listObjects(ctx context.Context, prefix string) ([]*s3.Object, error) {
+	contents := make([]*s3.Object, 0, elementCapacity)
+	var continuationToken *string
+	for finished := false; !finished; {
+		resp, err := s.listObjectsPage(ctx, prefix, continuationToken)
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, resp.Contents...)
+		if resp.IsTruncated != nil && (*resp.IsTruncated) {
+			continuationToken = resp.NextContinuationToken
+		} else {
+			finished = true
+		}
+	}
+
+	return contents, nil
+}
+
+// listObjectsPage lists a single page of objects with the given prefix.
+func (s *Store) listObjectsPage(ctx context.Context,
+	prefix string,
+	continuationToken *string,
+) (
+	*s3.ListObjectsV2Output,
+	error,
+) {
+	ctx, span := s.startSpan(ctx, "ListObjectsV2",
+		attribute.String("aws.s3.bucket", s.bucket),
+		attribute.String("aws.s3.prefix", prefix),
+	)
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+	resp, err := s.client.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:            aws.String(s.bucket),
+		Prefix:            aws.String(prefix),
+		ContinuationToken: continuationToken,
+	}, s.requestOptions(span, prefix)...)
+	if err == nil {
+		span.SetAttributes(attribute.Int("aws.s3.objects", len(resp.Contents)))
+	}
+	endSpan(span, err)
+
+	return resp, err
+}
+
+// listObjectsAfter lists up to maxKeys objects with the given prefix whose keys come after startAfter, or continue
+// from the continuation token if supplied.
+// If delimiter is not empty keys are rolled up into common prefixes at the delimiter.
+func (s *Store) listObjectsAfter(ctx context.Context,
+	prefix string,
+	delimiter string,
+	startAfter string,
+	continuationToken *string,
+	maxKeys int,
+) (
+	*s3.ListObjectsV2Output,
+	error,
+) {
+	ctx, span := s.startSpan(ctx, "ListObjectsV2",
+		attribute.String("aws.s3.bucket", s.bucket),
+		attribute.String("aws.s3.prefix", prefix),
+		attribute.String("aws.s3.start_after", startAfter),
+	)
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+	input := &s3.ListObjectsV2Input{
+		Bucket:            aws.String(s.bucket),
+		Prefix:            aws.String(prefix),
+		ContinuationToken: continuationToken,
+		MaxKeys:           aws.Int64(int64(maxKeys)),
+	}
+	if delimiter != "" {
+		input.Delimiter = aws.String(delimiter)
+	}
+	if startAfter != "" {
+		input.StartAfter = aws.String(startAfter)
+	}
+	resp, err := s.client.ListObjectsV2WithContext(ctx, input, s.requestOptions(span, prefix)...)
+	if err == nil {
+		span.SetAttributes(attribute.Int("aws.s3.objects", len(resp.Contents)+len(resp.CommonPrefixes)))
+	}
+	endSpan(span, err)
+
+	return resp, err
+}
+
+// download downloads the object with the given key.
+func (s *Store) download(ctx context.Context, key string) ([]byte, error) {
+	return s.downloadVersion(ctx, key, "")
+}
+
+// downloadVersion downloads the given version of the object with the given key.
+// If the version ID is empty the current version is downloaded.
+func (s *Store) downloadVersion(ctx context.Context, key string, versionID string) ([]byte, error) {
+	ctx, span := s.startSpan(ctx, "Download",
+		attribute.String("aws.s3.bucket", s.bucket),
+		attribute.String("aws.s3.key", key),
+	)
+	if versionID != "" {
+		span.SetAttributes(attribute.String("aws.s3.version_id", versionID))
+	}
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+	buf := aws.NewWriteAtBuffer(make([]byte, 0, itemCapacity))
+	downloader := s3manager.NewDownloaderWithClient(s.client, func(d *s3manager.Downloader) {
+		d.Concurrency = downloadConcurrency
+		d.RequestOptions = append(d.RequestOptions, s.requestOptions(span, key)...)
+	})
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if versionID != "" {
+		input.VersionId = aws.String(versionID)
+	}
+	_, err := downloader.DownloadWithContext(ctx, buf, input)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// uploadOption modifies the input of an upload of the given data.
+type uploadOption func(input *s3manager.UploadInput, data []byte)
+
+// upload uploads data to the object with the given key.
+func (s *Store) upload(ctx context.Context, key string, data []byte, opts ...uploadOption) error {
+	ctx, span := s.startSpan(ctx, "Upload",
+		attribute.String("aws.s3.bucket", s.bucket),
+		attribute.String("aws.s3.key", key),
+	)
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+	uploader := s3manager.NewUploaderWithClient(s.client, func(u *s3manager.Uploader) {
+		u.RequestOptions = append(u.RequestOptions, s.requestOptions(span, key)...)
+	})
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	}
+	for _, opt := range opts {
+		opt(input, data)
+	}
+	_, err := uploader.UploadWithContext(ctx, input)
+	endSpan(span, err)
+
+	return err
+}
+
+// putIfAbsent uploads data to the object with the given key only if the object does not already exist.
+// It returns false if the object already exists.
+func (s *Store) putIfAbsent(ctx context.Context, key string, data []byte) (bool, error) {
+	if s.providerProfile != nil && !s.providerProfile.conditionalWrites {
+		return s.putThenVerify(ctx, key, data)
+	}
+
+	return s.putConditional(ctx, key, data, "If-None-Match", "*")
+}
+
+// putIfMatch uploads data to the object with the given key only if the object has not changed since it had the
+// given ETag.  It returns false if the object has changed or no longer exists.
+// For services that do not support conditional writes the data is uploaded unconditionally.
+func (s *Store) putIfMatch(ctx context.Context, key string, data []byte, etag string) (bool, error) {
+	if s.providerProfile != nil && !s.providerProfile.conditionalWrites {
+		if err := s.upload(ctx, key, data); err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+
+	return s.putConditional(ctx, key, data, "If-Match", etag)
+}
+
+// putConditional uploads data to the object with the given key, subject to the given conditional header.
+// It returns false if the condition is not met.
+func (s *Store) putConditional(ctx context.Context, key string, data []byte, header string, value string) (bool, error) {
+	ctx, span := s.startSpan(ctx, "PutObject",
+		attribute.String("aws.s3.bucket", s.bucket),
+		attribute.String("aws.s3.key", key),
+	)
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+	opts := append(s.requestOptions(span, key), request.WithSetRequestHeaders(map[string]string{
+		header: value,
+	}))
+	_, err := s.client.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	}, opts...)
+	// A write conditional on an ETag fails with not found if the object has since been removed.
+	if isPreconditionFailed(err) || (header == "If-Match" && isKeyNotFound(err)) {
+		span.SetAttributes(attribute.Bool("aws.s3.precondition_failed", true))
+		endSpan(span, nil)
+
+		return false, nil
+	}
+	endSpan(span, err)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// objectInfo is information about an object obtained when it is downloaded.
+type objectInfo struct {
+	// etag is the ETag of the object.
+	etag string
+	// age is the time since the object was last modified, according to the service's clock.
+	age time.Duration
+}
+
+// downloadWithInfo downloads the object with the given key, returning its data and information about it.
+func (s *Store) downloadWithInfo(ctx context.Context, key string) ([]byte, *objectInfo, error) {
+	ctx, span := s.startSpan(ctx, "Download",
+		attribute.String("aws.s3.bucket", s.bucket),
+		attribute.String("aws.s3.key", key),
+	)
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+	req, resp := s.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	req.SetContext(ctx)
+	req.ApplyOptions(s.requestOptions(span, key)...)
+	if err := req.Send(); err != nil {
+		endSpan(span, err)
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	endSpan(span, err)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The age is measured against the date of the response, so that it is unaffected by the local clock.
+	now := time.Now()
+	if date, err := http.ParseTime(req.HTTPResponse.Header.Get("Date")); err == nil {
+		now = date
+	}
+
+	return data, &objectInfo{
+		etag: aws.StringValue(resp.ETag),
+		age:  now.Sub(aws.TimeValue(resp.LastModified)),
+	}, nil
+}
+
+// putThenVerify uploads data to the object with the given key if the object does not already exist, for services
+// that do not support conditional writes.  The check and the write are separate requests, so concurrent writers can
+// both find the object missing and both write it.  The object is read back afterwards so that a writer whose data
+// has already been overwritten does not report success, but more than one writer can still succeed.
+// It returns false if the object already exists.
+func (s *Store) putThenVerify(ctx context.Context, key string, data []byte) (bool, error) {
+	_, err := s.download(ctx, key)
+	if err == nil {
+		return false, nil
+	}
+	if !isKeyNotFound(err) {
+		return false, err
+	}
+
+	if err := s.upload(ctx, key, data); err != nil {
+		return false, err
+	}
+
+	stored, err := s.download(ctx, key)
+	if err != nil {
+		return false, err
+	}
+
+	return bytes.Equal(stored, data), nil
+}
+
+// remove removes the object with the given key.
+func (s *Store) remove(ctx context.Context, key string) error {
+	ctx, span := s.startSpan(ctx, "Delete",
+		attribute.String("aws.s3.bucket", s.bucket),
+		attribute.String("aws.s3.key", key),
+	)
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+	_, err := s.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}, s.requestOptions(span, key)...)
+	endSpan(span, err)
+
+	return err
+}
+
+// downloadLimited downloads the object with the given key, subject to the
+// store's concurrency limit.
+func (s *Store) downloadLimited(ctx context.Context, key string) ([]byte, error) {
+	if s.limiter == nil {
+		return s.download(ctx, key)
+	}
+
+	if err := s.limiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer s.limiter.release()
+
+	return s.download(ctx, key)
+}
+
+// uploadLimited uploads data to the object with the given key, subject to the
+// store's concurrency limit.
+func (s *Store) uploadLimited(ctx context.Context, key string, data []byte, opts ...uploadOption) error {
+	if s.limiter == nil {
+		return s.upload(ctx, key, data, opts...)
+	}
+
+	if err := s.limiter.acquire(ctx); err != nil {
+		return err
+	}
+	defer s.limiter.release()
+
+	return s.upload(ctx, key, data, opts...)
+}
+
+// decrypt decrypts data from the object with the given key if required.
+func (s *Store) decrypt(ctx context.Context, key string, data []byte) ([]byte, error) {
+	_, span := s.startSpan(ctx, "decryptIfRequired",
+		attribute.String("aws.s3.key", key),
+	)
+	data, err := s.decryptIfRequired(data)
+	endSpan(span, err)
+
+	return data, err
+}
@@ -0,0 +1,105 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// NotFoundError is returned when a store is opened without creation and its bucket or path does not exist.
+type NotFoundError struct {
+	// Bucket is the bucket of the store.
+	Bucket string
+	// Path is the path of the store.  It is empty if the bucket does not exist.
+	Path string
+}
+
+// Error implements the error interface.
+func (e *NotFoundError) Error() string {
+	if e.Path == "" {
+		return fmt.Sprintf("bucket %s not found", e.Bucket)
+	}
+
+	return fmt.Sprintf("path %s not found in bucket %s", e.Path, e.Bucket)
+}
+
+// Unwrap returns ErrBucketNotFound if the bucket does not exist.
+func (e *NotFoundError) Unwrap() error {
+	if e.Path == "" {
+		return ErrBucketNotFound
+	}
+
+	return nil
+}
+
+// WithCreate sets whether to create the bucket and path of the store if they do not exist.
+// This defaults to true.  If false the store only verifies that it can access the bucket and path, and returns a
+// *NotFoundError if either does not exist, so it can be used with credentials that cannot create buckets or
+// write objects.
+func WithCreate(t bool) Option {
+	return optionFunc(func(o *options) {
+		o.create = t
+	})
+}
+
+// Open opens an existing Amazon S3-compatible store without creating its bucket or path.
+// It takes the same options as New(), and returns a *NotFoundError if the store does not exist.
+func Open(opts ...Option) (wtypes.Store, error) {
+	return New(append(opts, WithCreate(false))...)
+}
+
+// Create creates an Amazon S3-compatible store, creating its bucket and path if they do not exist.
+// It takes the same options as New(), and creates a bucket generated from the credentials and ID if no bucket
+// is supplied and no existing bucket is found.
+func Create(opts ...Option) (wtypes.Store, error) {
+	return New(append(opts, WithCreate(true), WithCreateDerivedBucket(true))...)
+}
+
+// verifyStore verifies that the path of the store exists in the bucket and can be listed.
+// A path exists if any objects are present beneath it.
+func verifyStore(ctx context.Context,
+	conn s3iface.S3API,
+	bucket string,
+	path string,
+	reqOpts []request.Option,
+) error {
+	input := &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		MaxKeys: aws.Int64(1),
+	}
+	if path != "" {
+		input.Prefix = aws.String(fmt.Sprintf("%s/", path))
+	}
+	resp, err := conn.ListObjectsV2WithContext(ctx, input, reqOpts...)
+	if err != nil {
+		if isBucketNotFound(err) {
+			return &NotFoundError{Bucket: bucket}
+		}
+
+		return errors.Wrap(err, "unable to access store")
+	}
+	if path != "" && aws.Int64Value(resp.KeyCount) == 0 {
+		return &NotFoundError{Bucket: bucket, Path: path}
+	}
+
+	return nil
+}
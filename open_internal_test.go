@@ -0,0 +1,132 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/require"
+)
+
+// listOnlyClient is an S3 client that allows only listing of a fixed set of keys in a single bucket,
+// as available to a read-only role.
+type listOnlyClient struct {
+	s3iface.S3API
+	bucket string
+	keys   []string
+}
+
+func (c *listOnlyClient) ListObjectsV2WithContext(_ aws.Context,
+	input *s3.ListObjectsV2Input,
+	_ ...request.Option,
+) (
+	*s3.ListObjectsV2Output,
+	error,
+) {
+	if aws.StringValue(input.Bucket) != c.bucket {
+		return nil, awserr.New(s3.ErrCodeNoSuchBucket, "The specified bucket does not exist", nil)
+	}
+	output := &s3.ListObjectsV2Output{KeyCount: aws.Int64(0)}
+	for _, key := range c.keys {
+		if strings.HasPrefix(key, aws.StringValue(input.Prefix)) {
+			output.Contents = append(output.Contents, &s3.Object{Key: aws.String(key)})
+			output.KeyCount = aws.Int64(aws.Int64Value(output.KeyCount) + 1)
+		}
+	}
+
+	return output, nil
+}
+
+func (c *listOnlyClient) GetObjectWithContext(_ aws.Context,
+	_ *s3.GetObjectInput,
+	_ ...request.Option,
+) (
+	*s3.GetObjectOutput,
+	error,
+) {
+	return nil, awserr.NewRequestFailure(awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist", nil),
+		http.StatusNotFound,
+		"",
+	)
+}
+
+func TestOpen(t *testing.T) {
+	client := &listOnlyClient{
+		bucket: "bucket",
+		keys:   []string{"data/keystore/", "data/keystore/wallet/wallet"},
+	}
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		err      string
+		notFound *NotFoundError
+	}{
+		{
+			name:     "MissingBucket",
+			opts:     []Option{WithS3Client(client), WithBucket("missing")},
+			err:      "bucket missing not found",
+			notFound: &NotFoundError{Bucket: "missing"},
+		},
+		{
+			name:     "MissingPath",
+			opts:     []Option{WithS3Client(client), WithBucket("bucket"), WithPath("data/other")},
+			err:      "path data/other not found in bucket bucket",
+			notFound: &NotFoundError{Bucket: "bucket", Path: "data/other"},
+		},
+		{
+			name: "LegacyBucketMigration",
+			opts: []Option{WithS3Client(client), WithBucket("bucket"), WithLegacyBucketMigration(true)},
+			err:  "cannot migrate a legacy bucket without creating the store",
+		},
+		{
+			name: "Root",
+			opts: []Option{WithS3Client(client), WithBucket("bucket")},
+		},
+		{
+			name: "Path",
+			opts: []Option{WithS3Client(client), WithBucket("bucket"), WithPath("/data/keystore/")},
+		},
+		{
+			name: "CreateOverridden",
+			opts: []Option{WithS3Client(client), WithBucket("bucket"), WithPath("data/keystore"), WithCreate(true)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := Open(test.opts...)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				if test.notFound != nil {
+					var notFoundErr *NotFoundError
+					require.True(t, errors.As(err, &notFoundErr))
+					require.Equal(t, test.notFound, notFoundErr)
+					require.Equal(t, test.notFound.Path == "", errors.Is(err, ErrBucketNotFound))
+				}
+			} else {
+				require.NoError(t, err)
+				require.NotNil(t, store)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// defaultPageLimit is the number of items in a page if no limit is supplied.
+	defaultPageLimit = 100
+	// maxPageLimit is the maximum number of items in a page, which is the most S3 lists in a single request.
+	maxPageLimit = 1000
+)
+
+// PageOptions defines the page of items to list.
+type PageOptions struct {
+	// Limit is the maximum number of items in the page.  Defaults to 100, and cannot be more than 1000.
+	Limit int
+	// Cursor is the cursor returned with the previous page, or empty for the first page.
+	Cursor string
+}
+
+// Page is a page of items.
+type Page struct {
+	// Items are the items in the page, in order of their IDs.
+	Items [][]byte
+	// Cursor is the cursor for the next page, or empty if there are no more items.
+	// Cursors remain valid if items are added or removed.
+	Cursor string
+}
+
+// ListAccounts lists a page of account-level data for a wallet, in order of account ID.
+func (s *Store) ListAccounts(walletID uuid.UUID, opts PageOptions) (*Page, error) {
+	ctx, span := s.startSpan(context.Background(), "ListAccounts",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("cursor", opts.Cursor),
+	)
+	page, err := s.listAccounts(ctx, walletID, opts)
+	endSpan(span, err)
+
+	return page, err
+}
+
+func (s *Store) listAccounts(ctx context.Context, walletID uuid.UUID, opts PageOptions) (*Page, error) {
+	prefix := s.walletPath(walletID) + "/"
+	accountIDs, cursor, err := s.pageIDs(ctx, prefix, "", opts,
+		func(cursor uuid.UUID) string {
+			return prefix + cursor.String()
+		},
+		func(resp *s3.ListObjectsV2Output) []string {
+			ids := make([]string, 0, len(resp.Contents))
+			for _, content := range resp.Contents {
+				id := strings.TrimPrefix(aws.StringValue(content.Key), prefix)
+				if id != walletID.String() {
+					ids = append(ids, id)
+				}
+			}
+
+			return ids
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(accountIDs))
+	for i := range accountIDs {
+		keys[i] = s.accountPath(walletID, accountIDs[i])
+	}
+
+	return &Page{
+		Items:  s.downloadItems(ctx, keys),
+		Cursor: cursor,
+	}, nil
+}
+
+// ListWallets lists a page of wallet-level data, in order of wallet ID.
+func (s *Store) ListWallets(opts PageOptions) (*Page, error) {
+	ctx, span := s.startSpan(context.Background(), "ListWallets",
+		attribute.String("cursor", opts.Cursor),
+	)
+	page, err := s.listWallets(ctx, opts)
+	endSpan(span, err)
+
+	return page, err
+}
+
+func (s *Store) listWallets(ctx context.Context, opts PageOptions) (*Page, error) {
+	prefix := ""
+	if s.path != "" {
+		prefix = s.path + "/"
+	}
+	walletIDs, cursor, err := s.pageIDs(ctx, prefix, "/", opts,
+		func(cursor uuid.UUID) string {
+			// '0' sorts immediately after '/', so this follows all objects in the wallet.
+			return prefix + cursor.String() + "0"
+		},
+		func(resp *s3.ListObjectsV2Output) []string {
+			ids := make([]string, 0, len(resp.CommonPrefixes))
+			for _, commonPrefix := range resp.CommonPrefixes {
+				ids = append(ids, strings.TrimSuffix(strings.TrimPrefix(aws.StringValue(commonPrefix.Prefix), prefix), "/"))
+			}
+
+			return ids
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(walletIDs))
+	for i := range walletIDs {
+		keys[i] = s.walletHeaderPath(walletIDs[i])
+	}
+
+	return &Page{
+		Items:  s.downloadItems(ctx, keys),
+		Cursor: cursor,
+	}, nil
+}
+
+// pageIDs lists the IDs of the items in a page.  startAfter provides the key after which to start listing for a
+// cursor, and ids provides the candidate IDs in a response, which are ignored if they are not UUIDs.
+// It returns the IDs and the cursor for the next page.
+func (s *Store) pageIDs(ctx context.Context,
+	prefix string,
+	delimiter string,
+	opts PageOptions,
+	startAfter func(cursor uuid.UUID) string,
+	ids func(resp *s3.ListObjectsV2Output) []string,
+) (
+	[]uuid.UUID,
+	string,
+	error,
+) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = defaultPageLimit
+	}
+	if limit > maxPageLimit {
+		return nil, "", fmt.Errorf("page limit cannot be more than %d", maxPageLimit)
+	}
+	after := ""
+	if opts.Cursor != "" {
+		cursor, err := uuid.Parse(opts.Cursor)
+		if err != nil {
+			return nil, "", errors.New("invalid cursor")
+		}
+		after = startAfter(cursor)
+	}
+
+	res := make([]uuid.UUID, 0, limit)
+	var continuationToken *string
+	for {
+		resp, err := s.listObjectsAfter(ctx, prefix, delimiter, after, continuationToken, limit-len(res))
+		if err != nil {
+			return nil, "", errors.Wrap(err, "failed to list objects")
+		}
+		for _, id := range ids(resp) {
+			if parsed, err := uuid.Parse(id); err == nil {
+				res = append(res, parsed)
+			}
+		}
+		if !aws.BoolValue(resp.IsTruncated) {
+			return res, "", nil
+		}
+		if len(res) == limit {
+			return res, res[len(res)-1].String(), nil
+		}
+		continuationToken = resp.NextContinuationToken
+	}
+}
+
+// downloadItems downloads and decrypts the objects with the given keys concurrently, retaining their order.
+// Objects that cannot be downloaded or decrypted are skipped.
+func (s *Store) downloadItems(ctx context.Context, keys []string) [][]byte {
+	items := make([][]byte, len(keys))
+
+	// Download items concurrently.
+	wg := sync.WaitGroup{}
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			data, err := s.downloadLimited(ctx, key)
+			if err != nil {
+				s.log.Warn().Str("key", key).Str("code", errorCode(err)).Err(err).Msg("Failed to download object; skipping")
+				return
+			}
+			data, err = s.decrypt(ctx, key, data)
+			if err != nil {
+				s.log.Warn().Str("key", key).Err(err).Msg("Failed to decrypt object; skipping")
+				return
+			}
+			items[i] = data
+		}(i, key)
+	}
+	wg.Wait()
+
+	res := make([][]byte, 0, len(items))
+	for _, item := range items {
+		if item != nil {
+			res = append(res, item)
+		}
+	}
+
+	return res
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+// sortedIDs returns a number of random IDs in order.
+func sortedIDs(count int) []uuid.UUID {
+	ids := make([]uuid.UUID, count)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+	return ids
+}
+
+func TestListAccounts(t *testing.T) {
+	_, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithPath("store"))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+	require.NoError(t, s.StoreAccountsIndex(walletID, []byte("[]")))
+	accountIDs := sortedIDs(7)
+	for _, accountID := range accountIDs {
+		require.NoError(t, s.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":%q}`, accountID, accountID))))
+	}
+
+	// Page through the accounts.
+	listed := make([]string, 0)
+	cursor := ""
+	pages := 0
+	for {
+		page, err := s.ListAccounts(walletID, PageOptions{Limit: 3, Cursor: cursor})
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page.Items), 3)
+		for _, item := range page.Items {
+			listed = append(listed, string(item))
+		}
+		pages++
+		if page.Cursor == "" {
+			break
+		}
+		cursor = page.Cursor
+	}
+	require.Equal(t, 3, pages)
+	require.Len(t, listed, len(accountIDs))
+	for i, accountID := range accountIDs {
+		require.Contains(t, listed[i], accountID.String())
+	}
+
+	// An account added before the cursor does not disturb subsequent pages.
+	page, err := s.ListAccounts(walletID, PageOptions{Limit: 3})
+	require.NoError(t, err)
+	require.Contains(t, string(page.Items[2]), accountIDs[2].String())
+	earlyID := uuid.MustParse("00000000-0000-4000-8000-000000000000")
+	require.NoError(t, s.StoreAccount(walletID, earlyID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"early"}`, earlyID))))
+	page, err = s.ListAccounts(walletID, PageOptions{Limit: 3, Cursor: page.Cursor})
+	require.NoError(t, err)
+	require.Contains(t, string(page.Items[0]), accountIDs[3].String())
+
+	_, err = s.ListAccounts(walletID, PageOptions{Cursor: "../other"})
+	require.EqualError(t, err, "invalid cursor")
+	_, err = s.ListAccounts(walletID, PageOptions{Limit: 1001})
+	require.EqualError(t, err, "page limit cannot be more than 1000")
+}
+
+func TestListWallets(t *testing.T) {
+	for _, path := range []string{"", "store"} {
+		t.Run(fmt.Sprintf("Path%q", path), func(t *testing.T) {
+			_, client := newMemoryS3(t)
+			store, err := New(WithS3Client(client), WithBucket("bucket"), WithPath(path))
+			require.NoError(t, err)
+			s := store.(*Store)
+
+			walletIDs := sortedIDs(5)
+			for _, walletID := range walletIDs {
+				require.NoError(t, s.StoreWallet(walletID, walletID.String(), []byte(fmt.Sprintf(`{"uuid":%q,"name":%q}`, walletID, walletID))))
+				require.NoError(t, s.StoreAccountsIndex(walletID, []byte("[]")))
+				for i := 0; i < 3; i++ {
+					accountID := uuid.New()
+					require.NoError(t, s.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"account %d"}`, accountID, i))))
+				}
+			}
+
+			page, err := s.ListWallets(PageOptions{Limit: 2})
+			require.NoError(t, err)
+			require.Len(t, page.Items, 2)
+			require.Contains(t, string(page.Items[0]), walletIDs[0].String())
+			require.Contains(t, string(page.Items[1]), walletIDs[1].String())
+			require.Equal(t, walletIDs[1].String(), page.Cursor)
+
+			page, err = s.ListWallets(PageOptions{Limit: 2, Cursor: page.Cursor})
+			require.NoError(t, err)
+			require.Len(t, page.Items, 2)
+			require.Contains(t, string(page.Items[0]), walletIDs[2].String())
+
+			page, err = s.ListWallets(PageOptions{Cursor: page.Cursor})
+			require.NoError(t, err)
+			require.Len(t, page.Items, 1)
+			require.Contains(t, string(page.Items[0]), walletIDs[4].String())
+			require.Empty(t, page.Cursor)
+		})
+	}
+}
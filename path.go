@@ -13,7 +13,10 @@
 package s3
 
 import (
+	"encoding/hex"
+
 	"github.com/google/uuid"
+	util "github.com/wealdtech/go-eth2-util"
 )
 
 func (s *Store) walletPath(walletID uuid.UUID) string {
@@ -36,6 +39,18 @@ func (s *Store) walletBatchPath(walletID uuid.UUID) string {
 	return join(s.walletPath(walletID), "batch")
 }
 
+func (s *Store) pubKeyIndexPath(pubKey []byte) string {
+	return join(s.path, "pubkeys", hex.EncodeToString(util.SHA256(pubKey)))
+}
+
+func (s *Store) walletNamePath(name string) string {
+	return join(s.path, "names", hex.EncodeToString(util.SHA256([]byte(name))))
+}
+
+func (s *Store) accountNamePath(walletID uuid.UUID, name string) string {
+	return join(s.path, "names", walletID.String(), hex.EncodeToString(util.SHA256([]byte(name))))
+}
+
 // join joins multiple segments of a path.
 func join(elem ...string) string {
 	res := ""
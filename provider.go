@@ -0,0 +1,230 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+)
+
+// Provider is a provider of an S3-compatible service.
+type Provider string
+
+const (
+	// ProviderAWS is Amazon S3.
+	ProviderAWS Provider = "aws"
+	// ProviderMinIO is MinIO.
+	ProviderMinIO Provider = "minio"
+	// ProviderGCS is the interoperability API of Google Cloud Storage.
+	ProviderGCS Provider = "gcs"
+	// ProviderR2 is Cloudflare R2.
+	ProviderR2 Provider = "r2"
+	// ProviderCeph is the Ceph object gateway.
+	ProviderCeph Provider = "ceph"
+
+	// providerGeneric is used for S3-compatible services when no provider is supplied.
+	providerGeneric Provider = ""
+)
+
+// bucketProbe is the request used to check if a bucket exists.
+type bucketProbe int
+
+const (
+	// probeHeadBucket checks if a bucket exists with HeadBucket.
+	probeHeadBucket bucketProbe = iota
+	// probeListObjects checks if a bucket exists with ListObjectsV2, for services with limited support for HeadBucket.
+	probeListObjects
+)
+
+// providerProfile defines how the store interacts with a provider's service.
+type providerProfile struct {
+	// endpoint is the endpoint used if none is supplied.  If empty an endpoint must be supplied.
+	endpoint string
+	// region is the region used if none is supplied.
+	region string
+	// forcePathStyle is true if path-style addressing is used unless otherwise supplied.
+	forcePathStyle bool
+	// accountBuckets is true if generated bucket names are based on the account ID, which requires STS.
+	accountBuckets bool
+	// locationConstraint is true if buckets are created with the region as their location constraint.
+	locationConstraint bool
+	// bucketProbe is the request used to check if a bucket exists.
+	bucketProbe bucketProbe
+	// disableContentMD5Validation is true if the service does not support MD5 validation of object contents.
+	disableContentMD5Validation bool
+	// conditionalWrites is true if the service supports writes conditional on an object not existing.
+	conditionalWrites bool
+}
+
+// providerProfiles returns the profiles for each provider.
+func providerProfiles() map[Provider]*providerProfile {
+	return map[Provider]*providerProfile{
+		ProviderAWS: {
+			accountBuckets:     true,
+			locationConstraint: true,
+			bucketProbe:        probeHeadBucket,
+			conditionalWrites:  true,
+		},
+		ProviderMinIO: {
+			forcePathStyle:     true,
+			locationConstraint: true,
+			bucketProbe:        probeHeadBucket,
+			conditionalWrites:  true,
+		},
+		ProviderGCS: {
+			endpoint:                    "https://storage.googleapis.com",
+			locationConstraint:          true,
+			bucketProbe:                 probeListObjects,
+			disableContentMD5Validation: true,
+		},
+		ProviderR2: {
+			region:                      "auto",
+			forcePathStyle:              true,
+			bucketProbe:                 probeHeadBucket,
+			disableContentMD5Validation: true,
+			conditionalWrites:           true,
+		},
+		ProviderCeph: {
+			forcePathStyle:              true,
+			locationConstraint:          true,
+			bucketProbe:                 probeHeadBucket,
+			disableContentMD5Validation: true,
+			conditionalWrites:           true,
+		},
+		providerGeneric: {
+			locationConstraint: true,
+			bucketProbe:        probeListObjects,
+		},
+	}
+}
+
+// WithProvider sets the provider of the S3-compatible service, which selects the request used to check for
+// buckets, the addressing style, the region, the generation of bucket names, checksum validation, and conditional
+// writes.
+// If not supplied the provider is Amazon S3 if no endpoint is supplied, or a generic S3-compatible service otherwise.
+func WithProvider(t Provider) Option {
+	return optionFunc(func(o *options) {
+		o.provider = t
+	})
+}
+
+// applyProvider applies the defaults of the provider in the options.
+// Defaults are not applied to a supplied session or client, whose configuration is used as-is.
+func applyProvider(options *options) error {
+	if options.provider == "" {
+		return nil
+	}
+	profile, exists := providerProfiles()[options.provider]
+	if !exists || options.provider == providerGeneric {
+		return fmt.Errorf("unknown provider %q", options.provider)
+	}
+	if options.session != nil || options.s3Client != nil {
+		return nil
+	}
+
+	if options.endpoint == "" && options.provider != ProviderAWS {
+		if profile.endpoint == "" {
+			return fmt.Errorf("an endpoint must be supplied for provider %q", options.provider)
+		}
+		options.endpoint = profile.endpoint
+	}
+	if !options.regionSet && profile.region != "" {
+		options.region = profile.region
+		options.regionSet = true
+	}
+	if !options.forcePathStyleSet {
+		options.forcePathStyle = profile.forcePathStyle
+	}
+
+	return nil
+}
+
+// resolveProvider returns the profile of the provider in the options, inferring the provider if not supplied.
+func resolveProvider(options *options) *providerProfile {
+	if options.provider != "" {
+		return providerProfiles()[options.provider]
+	}
+	if options.endpoint == "" {
+		return providerProfiles()[ProviderAWS]
+	}
+
+	return providerProfiles()[providerGeneric]
+}
+
+// Providers differ in the error codes they return, and requests without a response body, such as HeadBucket and
+// HeadObject, only have a code based on the HTTP status, so errors are matched on both code and status.
+
+// isBucketNotFound returns true if the error shows that a bucket does not exist.
+func isBucketNotFound(err error) bool {
+	return errorCode(err) == "NoSuchBucket" || isHeadNotFound(err)
+}
+
+// isKeyNotFound returns true if the error shows that an object does not exist.
+// A missing bucket is not treated as a missing object.
+func isKeyNotFound(err error) bool {
+	return errorCode(err) == "NoSuchKey" || isHeadNotFound(err)
+}
+
+// isHeadNotFound returns true if the error is the not found response to a request without a response body, which
+// does not show whether it is the bucket or the object that does not exist.
+func isHeadNotFound(err error) bool {
+	return errorCode(err) == "NotFound" && statusCode(err) == http.StatusNotFound
+}
+
+// isPreconditionFailed returns true if the error shows that a conditional request was not carried out.
+// A conflict with a concurrent conditional request for the same object is treated in the same way.
+func isPreconditionFailed(err error) bool {
+	return errorCode(err) == "PreconditionFailed" ||
+		statusCode(err) == http.StatusPreconditionFailed ||
+		errorCode(err) == "ConditionalRequestConflict"
+}
+
+// isNoObjectLockConfiguration returns true if the error shows that an object has no retention or legal hold.
+func isNoObjectLockConfiguration(err error) bool {
+	return errorCode(err) == "NoSuchObjectLockConfiguration"
+}
+
+// isUnsupported returns true if the error shows that the service does not support a request.
+func isUnsupported(err error) bool {
+	switch errorCode(err) {
+	case "NotImplemented", "MethodNotAllowed", "XNotImplemented":
+		return true
+	default:
+		return statusCode(err) == http.StatusNotImplemented
+	}
+}
+
+// statusCode returns the HTTP status code of a failed request, or 0 if there is none.
+func statusCode(err error) int {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		return reqErr.StatusCode()
+	}
+
+	return 0
+}
+
+// withProviderCompatibility is a request option that adjusts requests to suit the provider.
+func withProviderCompatibility(p *providerProfile) request.Option {
+	return func(r *request.Request) {
+		if p.disableContentMD5Validation {
+			r.Config.S3DisableContentMD5Validation = aws.Bool(true)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyProvider(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           []Option
+		err            string
+		endpoint       string
+		region         string
+		forcePathStyle bool
+	}{
+		{
+			name:   "None",
+			region: defaultRegion,
+		},
+		{
+			name: "Unknown",
+			opts: []Option{WithProvider("unknown")},
+			err:  `unknown provider "unknown"`,
+		},
+		{
+			name:   "AWS",
+			opts:   []Option{WithProvider(ProviderAWS)},
+			region: defaultRegion,
+		},
+		{
+			name: "MinIONoEndpoint",
+			opts: []Option{WithProvider(ProviderMinIO)},
+			err:  `an endpoint must be supplied for provider "minio"`,
+		},
+		{
+			name:           "MinIO",
+			opts:           []Option{WithProvider(ProviderMinIO), WithEndpoint("http://localhost:9000")},
+			endpoint:       "http://localhost:9000",
+			region:         defaultRegion,
+			forcePathStyle: true,
+		},
+		{
+			name: "MinIOVirtualHosted",
+			opts: []Option{
+				WithForcePathStyle(false),
+				WithProvider(ProviderMinIO),
+				WithEndpoint("https://minio.example.com"),
+			},
+			endpoint: "https://minio.example.com",
+			region:   defaultRegion,
+		},
+		{
+			name:     "GCS",
+			opts:     []Option{WithProvider(ProviderGCS)},
+			endpoint: "https://storage.googleapis.com",
+			region:   defaultRegion,
+		},
+		{
+			name:           "R2",
+			opts:           []Option{WithProvider(ProviderR2), WithEndpoint("https://account.r2.cloudflarestorage.com")},
+			endpoint:       "https://account.r2.cloudflarestorage.com",
+			region:         "auto",
+			forcePathStyle: true,
+		},
+		{
+			name: "R2DefaultRegion",
+			opts: []Option{
+				WithRegion(defaultRegion),
+				WithProvider(ProviderR2),
+				WithEndpoint("https://account.r2.cloudflarestorage.com"),
+			},
+			endpoint:       "https://account.r2.cloudflarestorage.com",
+			region:         defaultRegion,
+			forcePathStyle: true,
+		},
+		{
+			name: "Client",
+			opts: []Option{WithProvider(ProviderR2), WithS3Client(&s3.S3{})},
+			// Defaults are not applied to a supplied client.
+			region: defaultRegion,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := options{
+				region: defaultRegion,
+			}
+			for _, o := range test.opts {
+				o.apply(&options)
+			}
+			err := applyProvider(&options)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, test.endpoint, options.endpoint)
+				require.Equal(t, test.region, options.region)
+				require.Equal(t, test.forcePathStyle, options.forcePathStyle)
+			}
+		})
+	}
+}
+
+func TestResolveProvider(t *testing.T) {
+	require.Equal(t, providerProfiles()[ProviderAWS], resolveProvider(&options{}))
+	require.Equal(t, providerProfiles()[providerGeneric], resolveProvider(&options{endpoint: "https://s3.example.com"}))
+	require.Equal(t, providerProfiles()[ProviderCeph], resolveProvider(&options{
+		endpoint: "https://s3.example.com",
+		provider: ProviderCeph,
+	}))
+}
+
+func TestErrorMatching(t *testing.T) {
+	noSuchBucket := awserr.NewRequestFailure(awserr.New("NoSuchBucket", "not found", nil), http.StatusNotFound, "")
+	headNotFound := awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), http.StatusNotFound, "")
+	notImplemented := awserr.NewRequestFailure(awserr.New("Unsupported", "", nil), http.StatusNotImplemented, "")
+	forbidden := awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), http.StatusForbidden, "")
+
+	require.True(t, isBucketNotFound(noSuchBucket))
+	require.True(t, isBucketNotFound(headNotFound))
+	require.False(t, isBucketNotFound(forbidden))
+	require.False(t, isBucketNotFound(errors.New("NoSuchBucket")))
+	require.True(t, isKeyNotFound(awserr.New("NoSuchKey", "not found", nil)))
+	require.True(t, isKeyNotFound(headNotFound))
+	require.False(t, isKeyNotFound(forbidden))
+	// A missing bucket is not a missing key, and vice versa.
+	noSuchKey := awserr.NewRequestFailure(awserr.New("NoSuchKey", "not found", nil), http.StatusNotFound, "")
+	require.False(t, isKeyNotFound(noSuchBucket))
+	require.False(t, isBucketNotFound(noSuchKey))
+	noSuchUpload := awserr.NewRequestFailure(awserr.New("NoSuchUpload", "not found", nil), http.StatusNotFound, "")
+	require.False(t, isKeyNotFound(noSuchUpload))
+	require.False(t, isBucketNotFound(noSuchUpload))
+	require.True(t, isUnsupported(notImplemented))
+	require.True(t, isUnsupported(awserr.New("NotImplemented", "", nil)))
+	require.False(t, isUnsupported(forbidden))
+	require.True(t, isPreconditionFailed(awserr.NewRequestFailure(
+		awserr.New("PreconditionFailed", "", nil), http.StatusPreconditionFailed, "")))
+	require.True(t, isPreconditionFailed(awserr.NewRequestFailure(
+		awserr.New("ConditionalRequestConflict", "", nil), http.StatusConflict, "")))
+	require.False(t, isPreconditionFailed(awserr.NewRequestFailure(
+		awserr.New("BucketNotEmpty", "", nil), http.StatusConflict, "")))
+	require.False(t, isPreconditionFailed(forbidden))
+}
+
+// probeClient is an S3 client that records the requests used to probe for a bucket.
+type probeClient struct {
+	s3iface.S3API
+	calls []string
+	err   error
+}
+
+func (c *probeClient) HeadBucketWithContext(_ aws.Context,
+	_ *s3.HeadBucketInput,
+	_ ...request.Option,
+) (
+	*s3.HeadBucketOutput,
+	error,
+) {
+	c.calls = append(c.calls, "HeadBucket")
+
+	return &s3.HeadBucketOutput{}, c.err
+}
+
+func (c *probeClient) ListObjectsV2WithContext(_ aws.Context,
+	_ *s3.ListObjectsV2Input,
+	_ ...request.Option,
+) (
+	*s3.ListObjectsV2Output,
+	error,
+) {
+	c.calls = append(c.calls, "ListObjectsV2")
+
+	return &s3.ListObjectsV2Output{}, c.err
+}
+
+func TestBucketExists(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider Provider
+		err      error
+		exists   bool
+		errStr   string
+		call     string
+	}{
+		{
+			name:     "AWS",
+			provider: ProviderAWS,
+			exists:   true,
+			call:     "HeadBucket",
+		},
+		{
+			name:     "AWSNotFound",
+			provider: ProviderAWS,
+			err:      awserr.NewRequestFailure(awserr.New("NotFound", "Not Found", nil), http.StatusNotFound, ""),
+			call:     "HeadBucket",
+		},
+		{
+			name:     "GCS",
+			provider: ProviderGCS,
+			exists:   true,
+			call:     "ListObjectsV2",
+		},
+		{
+			name:     "GCSForbidden",
+			provider: ProviderGCS,
+			err:      awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), http.StatusForbidden, ""),
+			errStr:   "unable to access bucket: AccessDenied: Access Denied\n\tstatus code: 403, request id: ",
+			call:     "ListObjectsV2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &probeClient{err: test.err}
+			exists, err := bucketExists(context.Background(), client, "bucket", providerProfiles()[test.provider], nil)
+			if test.errStr != "" {
+				require.EqualError(t, err, test.errStr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, test.exists, exists)
+			}
+			require.Equal(t, []string{test.call}, client.calls)
+		})
+	}
+}
+
+func TestWithProviderCompatibility(t *testing.T) {
+	for provider, disabled := range map[Provider]bool{
+		ProviderAWS:  false,
+		ProviderGCS:  true,
+		ProviderR2:   true,
+		ProviderCeph: true,
+	} {
+		r := &request.Request{}
+		withProviderCompatibility(providerProfiles()[provider])(r)
+		require.Equal(t, disabled, aws.BoolValue(r.Config.S3DisableContentMD5Validation), provider)
+	}
+}
+
+func TestMissingBucketNotEmpty(t *testing.T) {
+	_, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	// A bucket removed after the store is opened must not look like an empty store.
+	s.bucket = "missing"
+	_, err = s.readManifest(context.Background())
+	require.Error(t, err)
+	_, err = s.walletIndex(context.Background(), uuid.New())
+	require.Error(t, err)
+}
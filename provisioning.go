@@ -0,0 +1,237 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+// BucketProvisioning defines the security settings applied to a bucket when the store creates it.
+// Settings are not applied to existing buckets.
+type BucketProvisioning struct {
+	// BlockPublicAccess blocks all public access to the bucket and its objects.
+	BlockPublicAccess bool
+	// BucketOwnerEnforced disables access control lists, so the bucket owner owns all objects in the bucket.
+	BucketOwnerEnforced bool
+	// Encryption is the default server-side encryption for objects in the bucket, either "AES256" or "aws:kms".
+	// If empty the service's default encryption is used.
+	Encryption string
+	// KMSKeyID is the ID or ARN of the KMS key used for "aws:kms" encryption.
+	// If empty the AWS-managed key is used.
+	KMSKeyID string
+	// Versioning enables versioning of objects in the bucket.
+	Versioning bool
+	// ObjectLock enables S3 Object Lock for the bucket, which also enables versioning.
+	// Object Lock can only be enabled when a bucket is created.
+	ObjectLock bool
+	// RequireTLS sets a bucket policy that denies any request not made over TLS.
+	RequireTLS bool
+}
+
+// WithBucketProvisioning sets the security settings applied to a bucket when the store creates it.
+// If not supplied the bucket is created with the service's default settings.
+func WithBucketProvisioning(t BucketProvisioning) Option {
+	return optionFunc(func(o *options) {
+		o.bucketProvisioning = &t
+	})
+}
+
+// createBucket creates the bucket in the configured region, waits for it to exist, and applies the
+// provisioning settings.  If the settings cannot be applied the bucket is removed, so that a subsequent
+// attempt creates it afresh rather than using a bucket without the requested settings.
+func createBucket(ctx context.Context,
+	conn s3iface.S3API,
+	bucket string,
+	options *options,
+	log zerolog.Logger,
+	reqOpts []request.Option,
+) error {
+	if options.bucketProvisioning != nil && options.bucketProvisioning.Encryption != "" {
+		// Check the encryption settings before creating the bucket.
+		if _, err := encryptionRule(options.bucketProvisioning); err != nil {
+			return err
+		}
+	}
+
+	input := &s3.CreateBucketInput{
+		Bucket: aws.String(bucket),
+	}
+	if options.providerProfile.locationConstraint && options.region != "" && options.region != defaultRegion {
+		// Buckets in regions other than us-east-1 require an explicit location constraint.
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String(options.region),
+		}
+	}
+	if options.bucketProvisioning != nil && options.bucketProvisioning.BucketOwnerEnforced {
+		input.ObjectOwnership = aws.String(s3.ObjectOwnershipBucketOwnerEnforced)
+	}
+	if options.bucketProvisioning != nil && options.bucketProvisioning.ObjectLock {
+		input.ObjectLockEnabledForBucket = aws.Bool(true)
+	}
+	if _, err := conn.CreateBucketWithContext(ctx, input, reqOpts...); err != nil {
+		return errors.Wrap(err, "unable to create bucket")
+	}
+	err := conn.WaitUntilBucketExistsWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String(bucket)},
+		request.WithWaiterRequestOptions(reqOpts...),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to confirm bucket creation")
+	}
+
+	if options.bucketProvisioning == nil {
+		return nil
+	}
+	if err := provisionBucket(ctx, conn, bucket, options, reqOpts); err != nil {
+		if _, deleteErr := conn.DeleteBucketWithContext(ctx, &s3.DeleteBucketInput{
+			Bucket: aws.String(bucket),
+		}, reqOpts...); deleteErr != nil {
+			log.Error().Err(deleteErr).Msg("Failed to remove bucket after failing to provision it")
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// provisionBucket applies the provisioning settings to a newly-created bucket.
+func provisionBucket(ctx context.Context,
+	conn s3iface.S3API,
+	bucket string,
+	options *options,
+	reqOpts []request.Option,
+) error {
+	provisioning := options.bucketProvisioning
+
+	if provisioning.BlockPublicAccess {
+		if _, err := conn.PutPublicAccessBlockWithContext(ctx, &s3.PutPublicAccessBlockInput{
+			Bucket: aws.String(bucket),
+			PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+				BlockPublicAcls:       aws.Bool(true),
+				BlockPublicPolicy:     aws.Bool(true),
+				IgnorePublicAcls:      aws.Bool(true),
+				RestrictPublicBuckets: aws.Bool(true),
+			},
+		}, reqOpts...); err != nil {
+			return errors.Wrap(err, "failed to block public access to bucket")
+		}
+	}
+
+	if provisioning.Encryption != "" {
+		rule, err := encryptionRule(provisioning)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.PutBucketEncryptionWithContext(ctx, &s3.PutBucketEncryptionInput{
+			Bucket: aws.String(bucket),
+			ServerSideEncryptionConfiguration: &s3.ServerSideEncryptionConfiguration{
+				Rules: []*s3.ServerSideEncryptionRule{rule},
+			},
+		}, reqOpts...); err != nil {
+			return errors.Wrap(err, "failed to set bucket encryption")
+		}
+	}
+
+	if provisioning.Versioning {
+		if _, err := conn.PutBucketVersioningWithContext(ctx, &s3.PutBucketVersioningInput{
+			Bucket: aws.String(bucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{
+				Status: aws.String(s3.BucketVersioningStatusEnabled),
+			},
+		}, reqOpts...); err != nil {
+			return errors.Wrap(err, "failed to enable bucket versioning")
+		}
+	}
+
+	if provisioning.RequireTLS {
+		policy, err := tlsOnlyPolicy(bucket, options.region)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.PutBucketPolicyWithContext(ctx, &s3.PutBucketPolicyInput{
+			Bucket: aws.String(bucket),
+			Policy: aws.String(policy),
+		}, reqOpts...); err != nil {
+			return errors.Wrap(err, "failed to set bucket policy")
+		}
+	}
+
+	return nil
+}
+
+// encryptionRule returns the default encryption rule for the provisioning settings.
+func encryptionRule(provisioning *BucketProvisioning) (*s3.ServerSideEncryptionRule, error) {
+	rule := &s3.ServerSideEncryptionRule{
+		ApplyServerSideEncryptionByDefault: &s3.ServerSideEncryptionByDefault{
+			SSEAlgorithm: aws.String(provisioning.Encryption),
+		},
+	}
+	switch provisioning.Encryption {
+	case s3.ServerSideEncryptionAes256:
+		if provisioning.KMSKeyID != "" {
+			return nil, errors.New("cannot supply a KMS key ID with AES256 encryption")
+		}
+	case s3.ServerSideEncryptionAwsKms:
+		if provisioning.KMSKeyID != "" {
+			rule.ApplyServerSideEncryptionByDefault.KMSMasterKeyID = aws.String(provisioning.KMSKeyID)
+		}
+		rule.BucketKeyEnabled = aws.Bool(true)
+	default:
+		return nil, fmt.Errorf("unsupported bucket encryption %q", provisioning.Encryption)
+	}
+
+	return rule, nil
+}
+
+// tlsOnlyPolicy returns a bucket policy that denies any request not made over TLS.
+func tlsOnlyPolicy(bucket string, region string) (string, error) {
+	partition := endpoints.AwsPartitionID
+	if p, exists := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region); exists {
+		partition = p.ID()
+	}
+	bucketARN := fmt.Sprintf("arn:%s:s3:::%s", partition, bucket)
+
+	policy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Sid":       "DenyInsecureTransport",
+				"Effect":    "Deny",
+				"Principal": "*",
+				"Action":    "s3:*",
+				"Resource":  []string{bucketARN, bucketARN + "/*"},
+				"Condition": map[string]interface{}{
+					"Bool": map[string]string{
+						"aws:SecureTransport": "false",
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to generate bucket policy")
+	}
+
+	return string(policy), nil
+}
@@ -0,0 +1,257 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+// provisioningClient is an S3 client that records the bucket provisioning calls made to it.
+type provisioningClient struct {
+	s3iface.S3API
+	failVersioning bool
+	calls          []string
+	create         *s3.CreateBucketInput
+	encryption     *s3.ServerSideEncryptionRule
+	policy         string
+}
+
+func (c *provisioningClient) CreateBucketWithContext(_ aws.Context,
+	input *s3.CreateBucketInput,
+	_ ...request.Option,
+) (
+	*s3.CreateBucketOutput,
+	error,
+) {
+	c.calls = append(c.calls, "CreateBucket")
+	c.create = input
+
+	return &s3.CreateBucketOutput{}, nil
+}
+
+func (c *provisioningClient) WaitUntilBucketExistsWithContext(_ aws.Context,
+	_ *s3.HeadBucketInput,
+	_ ...request.WaiterOption,
+) error {
+	return nil
+}
+
+func (c *provisioningClient) PutPublicAccessBlockWithContext(_ aws.Context,
+	_ *s3.PutPublicAccessBlockInput,
+	_ ...request.Option,
+) (
+	*s3.PutPublicAccessBlockOutput,
+	error,
+) {
+	c.calls = append(c.calls, "PutPublicAccessBlock")
+
+	return &s3.PutPublicAccessBlockOutput{}, nil
+}
+
+func (c *provisioningClient) PutBucketEncryptionWithContext(_ aws.Context,
+	input *s3.PutBucketEncryptionInput,
+	_ ...request.Option,
+) (
+	*s3.PutBucketEncryptionOutput,
+	error,
+) {
+	c.calls = append(c.calls, "PutBucketEncryption")
+	c.encryption = input.ServerSideEncryptionConfiguration.Rules[0]
+
+	return &s3.PutBucketEncryptionOutput{}, nil
+}
+
+func (c *provisioningClient) PutBucketVersioningWithContext(_ aws.Context,
+	_ *s3.PutBucketVersioningInput,
+	_ ...request.Option,
+) (
+	*s3.PutBucketVersioningOutput,
+	error,
+) {
+	c.calls = append(c.calls, "PutBucketVersioning")
+	if c.failVersioning {
+		return nil, errors.New("access denied")
+	}
+
+	return &s3.PutBucketVersioningOutput{}, nil
+}
+
+func (c *provisioningClient) PutBucketPolicyWithContext(_ aws.Context,
+	input *s3.PutBucketPolicyInput,
+	_ ...request.Option,
+) (
+	*s3.PutBucketPolicyOutput,
+	error,
+) {
+	c.calls = append(c.calls, "PutBucketPolicy")
+	c.policy = aws.StringValue(input.Policy)
+
+	return &s3.PutBucketPolicyOutput{}, nil
+}
+
+func (c *provisioningClient) DeleteBucketWithContext(_ aws.Context,
+	_ *s3.DeleteBucketInput,
+	_ ...request.Option,
+) (
+	*s3.DeleteBucketOutput,
+	error,
+) {
+	c.calls = append(c.calls, "DeleteBucket")
+
+	return &s3.DeleteBucketOutput{}, nil
+}
+
+func TestCreateBucket(t *testing.T) {
+	hardened := BucketProvisioning{
+		BlockPublicAccess:   true,
+		BucketOwnerEnforced: true,
+		Encryption:          "aws:kms",
+		KMSKeyID:            "alias/wallet",
+		Versioning:          true,
+		RequireTLS:          true,
+	}
+
+	tests := []struct {
+		name           string
+		options        options
+		failVersioning bool
+		err            string
+		calls          []string
+		check          func(t *testing.T, client *provisioningClient)
+	}{
+		{
+			name:    "Default",
+			options: options{providerProfile: providerProfiles()[ProviderAWS], region: defaultRegion},
+			calls:   []string{"CreateBucket"},
+			check: func(t *testing.T, client *provisioningClient) {
+				t.Helper()
+				require.Nil(t, client.create.CreateBucketConfiguration)
+				require.Nil(t, client.create.ObjectOwnership)
+			},
+		},
+		{
+			name:    "Region",
+			options: options{providerProfile: providerProfiles()[ProviderAWS], region: "eu-west-2"},
+			calls:   []string{"CreateBucket"},
+			check: func(t *testing.T, client *provisioningClient) {
+				t.Helper()
+				require.Equal(t, "eu-west-2", aws.StringValue(client.create.CreateBucketConfiguration.LocationConstraint))
+			},
+		},
+		{
+			name:    "R2",
+			options: options{providerProfile: providerProfiles()[ProviderR2], region: "auto"},
+			calls:   []string{"CreateBucket"},
+			check: func(t *testing.T, client *provisioningClient) {
+				t.Helper()
+				require.Nil(t, client.create.CreateBucketConfiguration)
+			},
+		},
+		{
+			name: "ObjectLock",
+			options: options{
+				providerProfile:    providerProfiles()[ProviderAWS],
+				region:             defaultRegion,
+				bucketProvisioning: &BucketProvisioning{ObjectLock: true},
+			},
+			calls: []string{"CreateBucket"},
+			check: func(t *testing.T, client *provisioningClient) {
+				t.Helper()
+				require.True(t, aws.BoolValue(client.create.ObjectLockEnabledForBucket))
+			},
+		},
+		{
+			name:    "Hardened",
+			options: options{providerProfile: providerProfiles()[ProviderAWS], region: "cn-north-1", bucketProvisioning: &hardened},
+			calls: []string{
+				"CreateBucket",
+				"PutPublicAccessBlock",
+				"PutBucketEncryption",
+				"PutBucketVersioning",
+				"PutBucketPolicy",
+			},
+			check: func(t *testing.T, client *provisioningClient) {
+				t.Helper()
+				require.Equal(t, "BucketOwnerEnforced", aws.StringValue(client.create.ObjectOwnership))
+				require.Equal(t, "aws:kms", aws.StringValue(client.encryption.ApplyServerSideEncryptionByDefault.SSEAlgorithm))
+				require.Equal(t, "alias/wallet", aws.StringValue(client.encryption.ApplyServerSideEncryptionByDefault.KMSMasterKeyID))
+				require.True(t, aws.BoolValue(client.encryption.BucketKeyEnabled))
+				require.JSONEq(t, `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Sid": "DenyInsecureTransport",
+      "Effect": "Deny",
+      "Principal": "*",
+      "Action": "s3:*",
+      "Resource": ["arn:aws-cn:s3:::bucket", "arn:aws-cn:s3:::bucket/*"],
+      "Condition": {"Bool": {"aws:SecureTransport": "false"}}
+    }
+  ]
+}`, client.policy)
+			},
+		},
+		{
+			name:           "ProvisioningFailed",
+			options:        options{providerProfile: providerProfiles()[ProviderAWS], region: defaultRegion, bucketProvisioning: &hardened},
+			failVersioning: true,
+			err:            "failed to enable bucket versioning: access denied",
+			calls: []string{
+				"CreateBucket",
+				"PutPublicAccessBlock",
+				"PutBucketEncryption",
+				"PutBucketVersioning",
+				"DeleteBucket",
+			},
+		},
+		{
+			name: "AES256WithKMSKey",
+			options: options{providerProfile: providerProfiles()[ProviderAWS], region: defaultRegion, bucketProvisioning: &BucketProvisioning{
+				Encryption: "AES256",
+				KMSKeyID:   "alias/wallet",
+			}},
+			err: "cannot supply a KMS key ID with AES256 encryption",
+		},
+		{
+			name: "UnknownEncryption",
+			options: options{providerProfile: providerProfiles()[ProviderAWS], region: defaultRegion, bucketProvisioning: &BucketProvisioning{
+				Encryption: "rot13",
+			}},
+			err: `unsupported bucket encryption "rot13"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &provisioningClient{failVersioning: test.failVersioning}
+			err := createBucket(context.Background(), client, "bucket", &test.options, zerolog.Nop(), nil)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+				test.check(t, client)
+			}
+			require.Equal(t, test.calls, client.calls)
+		})
+	}
+}
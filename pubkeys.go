@@ -0,0 +1,195 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ErrPubKeyNotFound is returned when no account is found for a public key.
+var ErrPubKeyNotFound = errors.New("no account found for public key")
+
+// pubKeyEntry is an entry in the public key index, locating the account with the public key.
+type pubKeyEntry struct {
+	WalletID  uuid.UUID `json:"wallet"`
+	AccountID uuid.UUID `json:"account"`
+}
+
+// accountPubKeys returns the public keys of an account, which are its own public key and, for distributed accounts,
+// its composite public key.
+func accountPubKeys(data []byte) ([][]byte, error) {
+	info := &struct {
+		PubKey          string `json:"pubkey"`
+		CompositePubKey string `json:"composite_pubkey"`
+	}{}
+	if err := json.Unmarshal(data, info); err != nil {
+		return nil, errors.Wrap(err, "failed to parse account")
+	}
+
+	pubKeys := make([][]byte, 0, 2)
+	for _, pubKey := range []string{info.PubKey, info.CompositePubKey} {
+		if pubKey == "" {
+			continue
+		}
+		key, err := hex.DecodeString(strings.TrimPrefix(pubKey, "0x"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid public key")
+		}
+		pubKeys = append(pubKeys, key)
+	}
+
+	return pubKeys, nil
+}
+
+// indexPubKeys adds the public keys of an account to the public key index.
+func (s *Store) indexPubKeys(ctx context.Context, walletID uuid.UUID, accountID uuid.UUID, pubKeys [][]byte) error {
+	if len(pubKeys) == 0 {
+		return nil
+	}
+
+	entry, err := json.Marshal(&pubKeyEntry{
+		WalletID:  walletID,
+		AccountID: accountID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to create public key index entry")
+	}
+	entry, err = s.encryptIfRequired(entry)
+	if err != nil {
+		return errors.Wrap(err, "failed to encrypt public key index entry")
+	}
+	for _, pubKey := range pubKeys {
+		if err := s.upload(ctx, s.pubKeyIndexPath(pubKey), entry, s.withObjectAttributes(ObjectKindPubKeyIndex)); err != nil {
+			return errors.Wrap(err, "failed to store public key index entry")
+		}
+	}
+
+	return nil
+}
+
+// RetrieveAccountByPubKey retrieves the account with the given public key, or for distributed accounts the given
+// composite public key.  It returns ErrPubKeyNotFound if there is no such account.
+// Accounts are found using an index maintained by StoreAccount(); accounts stored by earlier versions of this module
+// are added to the index by RebuildPubKeyIndex().
+func (s *Store) RetrieveAccountByPubKey(pubKey []byte) ([]byte, error) {
+	ctx, span := s.startSpan(context.Background(), "RetrieveAccountByPubKey",
+		attribute.String("pubkey", fmt.Sprintf("%#x", pubKey)),
+	)
+	data, err := s.retrieveAccountByPubKey(ctx, pubKey)
+	endSpan(span, err)
+
+	return data, err
+}
+
+func (s *Store) retrieveAccountByPubKey(ctx context.Context, pubKey []byte) ([]byte, error) {
+	path := s.pubKeyIndexPath(pubKey)
+	data, err := s.download(ctx, path)
+	if err != nil {
+		if isKeyNotFound(err) {
+			return nil, ErrPubKeyNotFound
+		}
+
+		return nil, errors.Wrap(err, "failed to retrieve public key index entry")
+	}
+	data, err = s.decrypt(ctx, path, data)
+	if err != nil {
+		return nil, err
+	}
+	entry := &pubKeyEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		return nil, errors.Wrap(err, "failed to parse public key index entry")
+	}
+
+	account, err := s.retrieveAccount(ctx, entry.WalletID, entry.AccountID)
+	if err != nil {
+		if isKeyNotFound(err) {
+			// The account has been removed.
+			return nil, ErrPubKeyNotFound
+		}
+
+		return nil, err
+	}
+
+	// The account may have been overwritten with a different key since the entry was written.
+	pubKeys, err := accountPubKeys(account)
+	if err != nil {
+		return nil, err
+	}
+	for _, accountPubKey := range pubKeys {
+		if bytes.Equal(accountPubKey, pubKey) {
+			return account, nil
+		}
+	}
+
+	return nil, ErrPubKeyNotFound
+}
+
+// RebuildPubKeyIndex adds all accounts in the store to the public key index.
+// This is only required for accounts stored by earlier versions of this module, as StoreAccount() maintains the index.
+func (s *Store) RebuildPubKeyIndex() error {
+	ctx, span := s.startSpan(context.Background(), "RebuildPubKeyIndex")
+	err := s.rebuildPubKeyIndex(ctx)
+	endSpan(span, err)
+
+	return err
+}
+
+func (s *Store) rebuildPubKeyIndex(ctx context.Context) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	contents, err := s.listObjects(ctx, s.path)
+	if err != nil {
+		return errors.Wrap(err, "failed to list objects")
+	}
+	for _, content := range contents {
+		// This is only an account if the last two components of the path are different wallet and account IDs.
+		components := strings.Split(*content.Key, "/")
+		if len(components) < 2 || components[len(components)-1] == components[len(components)-2] {
+			continue
+		}
+		walletID, err := uuid.Parse(components[len(components)-2])
+		if err != nil {
+			continue
+		}
+		accountID, err := uuid.Parse(components[len(components)-1])
+		if err != nil {
+			continue
+		}
+
+		data, err := s.retrieveAccount(ctx, walletID, accountID)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to retrieve %s", *content.Key))
+		}
+		pubKeys, err := accountPubKeys(data)
+		if err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to obtain public keys of %s", *content.Key))
+		}
+		if err := s.indexPubKeys(ctx, walletID, accountID, pubKeys); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("failed to index %s", *content.Key))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func pubKey(t *testing.T, input string) []byte {
+	t.Helper()
+	res, err := hex.DecodeString(input)
+	require.NoError(t, err)
+
+	return res
+}
+
+func TestAccountPubKeys(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		pubKeys int
+		err     string
+	}{
+		{
+			name: "Invalid",
+			data: `{`,
+			err:  "failed to parse account: unexpected end of JSON input",
+		},
+		{
+			name: "None",
+			data: `{"name":"account"}`,
+		},
+		{
+			name:    "PubKey",
+			data:    `{"pubkey":"a99a76ed7796f7be22d5b7e85deeb7c5677e88e511e0b337618f8c4eb61349b4bf2d153f649f7b53359fe8b94a38e44c"}`,
+			pubKeys: 1,
+		},
+		{
+			name:    "Prefixed",
+			data:    `{"pubkey":"0xa99a76ed7796f7be22d5b7e85deeb7c5677e88e511e0b337618f8c4eb61349b4bf2d153f649f7b53359fe8b94a38e44c"}`,
+			pubKeys: 1,
+		},
+		{
+			name:    "Distributed",
+			data:    `{"pubkey":"a99a","composite_pubkey":"b89b"}`,
+			pubKeys: 2,
+		},
+		{
+			name: "BadPubKey",
+			data: `{"pubkey":"invalid"}`,
+			err:  "invalid public key: encoding/hex: invalid byte: U+0069 'i'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pubKeys, err := accountPubKeys([]byte(test.data))
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+				require.Len(t, pubKeys, test.pubKeys)
+			}
+		})
+	}
+}
+
+func TestRetrieveAccountByPubKey(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithPath("store"), WithPassphrase([]byte("secret")))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	accountID := uuid.New()
+	key1 := "a99a76ed7796f7be22d5b7e85deeb7c5677e88e511e0b337618f8c4eb61349b4bf2d153f649f7b53359fe8b94a38e44c"
+	key2 := "b89bebc699769726a318c8e9971bd3171297c61aea4a6578a7a4f94b547dcba5bac16a89108b6b6a1fe3695d1a874a0b"
+	account := func(pubKey string) []byte {
+		return []byte(fmt.Sprintf(`{"uuid":%q,"name":"account","pubkey":%q}`, accountID, pubKey))
+	}
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+	require.NoError(t, s.StoreAccount(walletID, accountID, account(key1)))
+
+	// The index entry is encrypted.
+	require.NotContains(t, string(m.object("bucket", s.pubKeyIndexPath(pubKey(t, key1)))), walletID.String())
+
+	data, err := s.RetrieveAccountByPubKey(pubKey(t, key1))
+	require.NoError(t, err)
+	require.Equal(t, account(key1), data)
+
+	_, err = s.RetrieveAccountByPubKey(pubKey(t, key2))
+	require.ErrorIs(t, err, ErrPubKeyNotFound)
+
+	// Overwrite the account with a different key; the stale entry is ignored.
+	require.NoError(t, s.StoreAccount(walletID, accountID, account(key2)))
+	_, err = s.RetrieveAccountByPubKey(pubKey(t, key1))
+	require.ErrorIs(t, err, ErrPubKeyNotFound)
+	data, err = s.RetrieveAccountByPubKey(pubKey(t, key2))
+	require.NoError(t, err)
+	require.Equal(t, account(key2), data)
+
+	// Restoring the earlier version makes it available again.
+	versions, err := s.ListAccountVersions(walletID, accountID)
+	require.NoError(t, err)
+	require.NoError(t, s.RestoreAccountVersion(walletID, accountID, versions[1].VersionID))
+	data, err = s.RetrieveAccountByPubKey(pubKey(t, key1))
+	require.NoError(t, err)
+	require.Equal(t, account(key1), data)
+}
+
+func TestRebuildPubKeyIndex(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	accountID := uuid.New()
+	key := "a99a76ed7796f7be22d5b7e85deeb7c5677e88e511e0b337618f8c4eb61349b4bf2d153f649f7b53359fe8b94a38e44c"
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+	require.NoError(t, s.StoreAccountsIndex(walletID, []byte("[]")))
+	// Write the account directly, as an earlier version of the module would have.
+	m.putObject("bucket", s.accountPath(walletID, accountID),
+		[]byte(fmt.Sprintf(`{"uuid":%q,"name":"account","pubkey":%q}`, accountID, key)))
+
+	_, err = s.RetrieveAccountByPubKey(pubKey(t, key))
+	require.ErrorIs(t, err, ErrPubKeyNotFound)
+
+	require.NoError(t, s.RebuildPubKeyIndex())
+	_, err = s.RetrieveAccountByPubKey(pubKey(t, key))
+	require.NoError(t, err)
+}
@@ -0,0 +1,47 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/pkg/errors"
+)
+
+// ErrReadOnly is returned when an attempt is made to write to a read-only store.
+var ErrReadOnly = errors.New("store is read-only")
+
+// WithReadOnly sets whether the store is read-only.
+// A read-only store does not create its bucket or path, returns ErrReadOnly from all methods that write data, and
+// refuses to send any request to S3 that could modify the store, regardless of the permissions of its credentials.
+// This defaults to false.
+func WithReadOnly(t bool) Option {
+	return optionFunc(func(o *options) {
+		o.readOnly = t
+	})
+}
+
+// withReadOnlyGuard is a request option that fails any request that could modify the store with ErrReadOnly.
+func withReadOnlyGuard() request.Option {
+	return func(r *request.Request) {
+		r.Handlers.Validate.PushBack(func(r *request.Request) {
+			switch r.Operation.HTTPMethod {
+			case http.MethodGet, http.MethodHead:
+			default:
+				r.Error = ErrReadOnly
+			}
+		})
+	}
+}
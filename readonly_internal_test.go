@@ -0,0 +1,99 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadOnlyStore(t *testing.T) {
+	s := &Store{readOnly: true}
+	walletID := uuid.New()
+
+	require.True(t, errors.Is(s.StoreWallet(walletID, "wallet", []byte("{}")), ErrReadOnly))
+	require.True(t, errors.Is(s.StoreAccount(walletID, uuid.New(), []byte("{}")), ErrReadOnly))
+	require.True(t, errors.Is(s.StoreAccountsIndex(walletID, []byte("[]")), ErrReadOnly))
+	require.True(t, errors.Is(s.StoreBatch(context.Background(), walletID, "wallet", []byte("{}")), ErrReadOnly))
+}
+
+func TestReadOnlyGuard(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(srv.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+	})
+	require.NoError(t, err)
+	conn := s3.New(sess)
+	ctx := context.Background()
+
+	_, err = conn.HeadBucketWithContext(ctx, &s3.HeadBucketInput{Bucket: aws.String("bucket")}, withReadOnlyGuard())
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	_, err = conn.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("key"),
+		Body:   strings.NewReader("data"),
+	}, withReadOnlyGuard())
+	require.Equal(t, ErrReadOnly, err)
+
+	_, err = conn.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("key"),
+	}, withReadOnlyGuard())
+	require.Equal(t, ErrReadOnly, err)
+
+	_, err = conn.CreateBucketWithContext(ctx, &s3.CreateBucketInput{Bucket: aws.String("bucket")}, withReadOnlyGuard())
+	require.Equal(t, ErrReadOnly, err)
+
+	// Requests that could modify the store never reach the service.
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
+
+func TestReadOnlyNew(t *testing.T) {
+	// The client supports only listing, so any attempt to write panics.
+	client := &listOnlyClient{
+		bucket: "bucket",
+		keys:   []string{"data/keystore/"},
+	}
+
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithPath("data/keystore"), WithReadOnly(true))
+	require.NoError(t, err)
+	require.True(t, store.(*Store).readOnly)
+
+	_, err = Create(WithS3Client(client), WithBucket("bucket"), WithPath("data/other"), WithReadOnly(true))
+	var notFoundErr *NotFoundError
+	require.True(t, errors.As(err, &notFoundErr))
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// RetryPolicy defines how requests to S3 are retried.
+// Fields that are not set take the same defaults as the AWS SDK's own retryer.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts for each request, including the first.
+	// Defaults to 4; set to 1 to disable retries.
+	MaxAttempts int
+	// MinBackoff is the upper bound of the delay before the first retry.  Defaults to 30ms.
+	MinBackoff time.Duration
+	// MinThrottleBackoff is the upper bound of the delay before the first retry if
+	// S3 is throttling requests.  Defaults to MinBackoff if that is set, otherwise to 500ms.
+	MinThrottleBackoff time.Duration
+	// MaxBackoff is the maximum delay between retries.  Defaults to 300s.
+	MaxBackoff time.Duration
+	// OperationTimeout is the maximum time for a single operation, including all
+	// of its retries.  0 means no timeout.
+	OperationTimeout time.Duration
+}
+
+// retryer is a request.Retryer that carries out exponential backoff with full jitter.
+type retryer struct {
+	policy RetryPolicy
+}
+
+// newRetryer creates a retryer for the given policy.
+func newRetryer(policy RetryPolicy) *retryer {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = client.DefaultRetryerMaxNumRetries + 1
+	}
+	if policy.MinThrottleBackoff == 0 {
+		if policy.MinBackoff == 0 {
+			policy.MinThrottleBackoff = client.DefaultRetryerMinThrottleDelay
+		} else {
+			policy.MinThrottleBackoff = policy.MinBackoff
+		}
+	}
+	if policy.MinBackoff == 0 {
+		policy.MinBackoff = client.DefaultRetryerMinRetryDelay
+	}
+	if policy.MaxBackoff == 0 {
+		policy.MaxBackoff = client.DefaultRetryerMaxRetryDelay
+	}
+	if policy.MinThrottleBackoff < policy.MinBackoff {
+		policy.MinThrottleBackoff = policy.MinBackoff
+	}
+	if policy.MaxBackoff < policy.MinThrottleBackoff {
+		policy.MaxBackoff = policy.MinThrottleBackoff
+	}
+
+	return &retryer{
+		policy: policy,
+	}
+}
+
+// MaxRetries returns the maximum number of retries for a request.
+func (r *retryer) MaxRetries() int {
+	return r.policy.MaxAttempts - 1
+}
+
+// ShouldRetry returns true if the failed request should be retried.
+func (r *retryer) ShouldRetry(req *request.Request) bool {
+	if req.Retryable != nil {
+		return *req.Retryable
+	}
+
+	return req.IsErrorRetryable() || req.IsErrorThrottle()
+}
+
+// RetryRules returns the delay before the request is retried.
+func (r *retryer) RetryRules(req *request.Request) time.Duration {
+	minBackoff := r.policy.MinBackoff
+	if req.IsErrorThrottle() {
+		minBackoff = r.policy.MinThrottleBackoff
+	}
+
+	return r.backoff(minBackoff, req.RetryCount)
+}
+
+// backoff returns a random delay between 0 and the exponential backoff for the
+// given retry, capped at the maximum backoff.
+func (r *retryer) backoff(minBackoff time.Duration, retryCount int) time.Duration {
+	ceiling := minBackoff
+	for i := 0; i < retryCount && ceiling < r.policy.MaxBackoff; i++ {
+		ceiling *= 2
+	}
+	if ceiling > r.policy.MaxBackoff {
+		ceiling = r.policy.MaxBackoff
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+
+	// #nosec G404
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// withRetryer is a request option that uses the retryer for the request.
+func withRetryer(r *retryer) request.Option {
+	return func(req *request.Request) {
+		req.Retryer = r
+	}
+}
+
+// operationContext returns a context bounded by the operation timeout, if set.
+func (s *Store) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.retryer == nil || s.retryer.policy.OperationTimeout == 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, s.retryer.policy.OperationTimeout)
+}
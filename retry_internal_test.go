@@ -0,0 +1,214 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRetryer(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     RetryPolicy
+		maxRetries int
+		expected   RetryPolicy
+	}{
+		{
+			name:       "Empty",
+			policy:     RetryPolicy{},
+			maxRetries: 3,
+			expected: RetryPolicy{
+				MaxAttempts:        4,
+				MinBackoff:         30 * time.Millisecond,
+				MinThrottleBackoff: 500 * time.Millisecond,
+				MaxBackoff:         300 * time.Second,
+			},
+		},
+		{
+			name: "TimeoutOnly",
+			policy: RetryPolicy{
+				OperationTimeout: time.Minute,
+			},
+			maxRetries: 3,
+			expected: RetryPolicy{
+				MaxAttempts:        4,
+				MinBackoff:         30 * time.Millisecond,
+				MinThrottleBackoff: 500 * time.Millisecond,
+				MaxBackoff:         300 * time.Second,
+				OperationTimeout:   time.Minute,
+			},
+		},
+		{
+			name: "NoRetries",
+			policy: RetryPolicy{
+				MaxAttempts: 1,
+			},
+			maxRetries: 0,
+			expected: RetryPolicy{
+				MaxAttempts:        1,
+				MinBackoff:         30 * time.Millisecond,
+				MinThrottleBackoff: 500 * time.Millisecond,
+				MaxBackoff:         300 * time.Second,
+			},
+		},
+		{
+			name: "ThrottleDefault",
+			policy: RetryPolicy{
+				MaxAttempts: 5,
+				MinBackoff:  10 * time.Millisecond,
+				MaxBackoff:  time.Second,
+			},
+			maxRetries: 4,
+			expected: RetryPolicy{
+				MaxAttempts:        5,
+				MinBackoff:         10 * time.Millisecond,
+				MinThrottleBackoff: 10 * time.Millisecond,
+				MaxBackoff:         time.Second,
+			},
+		},
+		{
+			name: "MaxBelowMin",
+			policy: RetryPolicy{
+				MaxAttempts:        3,
+				MinBackoff:         10 * time.Millisecond,
+				MinThrottleBackoff: 100 * time.Millisecond,
+				MaxBackoff:         time.Millisecond,
+			},
+			maxRetries: 2,
+			expected: RetryPolicy{
+				MaxAttempts:        3,
+				MinBackoff:         10 * time.Millisecond,
+				MinThrottleBackoff: 100 * time.Millisecond,
+				MaxBackoff:         100 * time.Millisecond,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newRetryer(test.policy)
+			require.Equal(t, test.expected, r.policy)
+			require.Equal(t, test.maxRetries, r.MaxRetries())
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	r := newRetryer(RetryPolicy{
+		MaxAttempts: 10,
+		MinBackoff:  10 * time.Millisecond,
+		MaxBackoff:  100 * time.Millisecond,
+	})
+
+	for i := 0; i < 1000; i++ {
+		require.LessOrEqual(t, r.backoff(r.policy.MinBackoff, 0), 10*time.Millisecond)
+		require.LessOrEqual(t, r.backoff(r.policy.MinBackoff, 2), 40*time.Millisecond)
+		require.LessOrEqual(t, r.backoff(r.policy.MinBackoff, 8), 100*time.Millisecond)
+		require.GreaterOrEqual(t, r.backoff(r.policy.MinBackoff, 8), time.Duration(0))
+	}
+	require.Equal(t, time.Duration(0), r.backoff(0, 3))
+}
+
+func TestRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int32
+		policy   RetryPolicy
+		requests int32
+		err      bool
+	}{
+		{
+			name:     "Succeeds",
+			failures: 2,
+			policy: RetryPolicy{
+				MaxAttempts: 3,
+				MinBackoff:  time.Millisecond,
+				MaxBackoff:  time.Millisecond,
+			},
+			requests: 3,
+		},
+		{
+			name:     "Exhausted",
+			failures: 5,
+			policy: RetryPolicy{
+				MaxAttempts: 2,
+				MinBackoff:  time.Millisecond,
+				MaxBackoff:  time.Millisecond,
+			},
+			requests: 2,
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if atomic.AddInt32(&requests, 1) <= test.failures {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			sess, err := session.NewSession(&aws.Config{
+				Region:           aws.String("us-east-1"),
+				Endpoint:         aws.String(srv.URL),
+				S3ForcePathStyle: aws.Bool(true),
+				Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+			})
+			require.NoError(t, err)
+
+			l := newLimiter(8)
+			_, err = s3.New(sess).HeadBucketWithContext(context.Background(),
+				&s3.HeadBucketInput{Bucket: aws.String("bucket")},
+				withRetryer(newRetryer(test.policy)),
+				withThrottleDetection(l),
+			)
+			if test.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, test.requests, atomic.LoadInt32(&requests))
+			require.Less(t, l.currentLimit(), 8)
+		})
+	}
+}
+
+func TestOperationContext(t *testing.T) {
+	s := &Store{}
+	ctx, cancel := s.operationContext(context.Background())
+	defer cancel()
+	_, hasDeadline := ctx.Deadline()
+	require.False(t, hasDeadline)
+
+	s.retryer = newRetryer(RetryPolicy{OperationTimeout: time.Minute})
+	ctx, cancel = s.operationContext(context.Background())
+	defer cancel()
+	_, hasDeadline = ctx.Deadline()
+	require.True(t, hasDeadline)
+}
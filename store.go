@@ -14,21 +14,28 @@
 package s3
 
 import (
-	"encoding/hex"
+	"context"
+	"crypto/tls"
 	"fmt"
+	"net/http"
 	"path/filepath"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	session "github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/pkg/errors"
-	util "github.com/wealdtech/go-eth2-util"
+	"github.com/rs/zerolog"
 	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 const (
+	defaultRegion       = "us-east-1"
 	downloadConcurrency = 64
 	elementCapacity     = 16384
 	itemCapacity        = 2048
@@ -36,15 +43,46 @@ const (
 
 // options are the options for the S3 store.
 type options struct {
-	id                []byte
-	endpoint          string
-	region            string
-	bucket            string
-	path              string
-	passphrase        []byte
-	credentialsID     string
-	credentialsSecret string
-	forcePathStyle    bool
+	id                      []byte
+	endpoint                string
+	region                  string
+	regionSet               bool
+	bucket                  string
+	path                    string
+	passphrase              redacted
+	credentialsID           string
+	credentialsSecret       redactedString
+	credentialsSessionToken redactedString
+	credentialsProvider     credentials.Provider
+	profile                 string
+	assumeRoleARN           string
+	assumeRoleExternalID    string
+	assumeRoleSessionName   string
+	webIdentityTokenFile    string
+	forcePathStyle          bool
+	forcePathStyleSet       bool
+	provider                Provider
+	providerProfile         *providerProfile
+	tracerProvider          trace.TracerProvider
+	logger                  zerolog.Logger
+	retryPolicy             *RetryPolicy
+	maxConcurrency          int
+	bucketProvisioning      *BucketProvisioning
+	objectLock              *ObjectLock
+	objectAttributes        map[ObjectKind]*ObjectAttributes
+	create                  bool
+	readOnly                bool
+	migrating               bool
+	auditMode               AuditMode
+	createDerivedBucket     bool
+	legacyBucketMigration   bool
+	httpClient              *http.Client
+	caBundle                []byte
+	clientCertificate       *tls.Certificate
+	proxy                   string
+	connectionPool          *ConnectionPool
+	session                 *session.Session
+	s3Client                s3iface.S3API
 }
 
 // Option gives options to New.
@@ -80,11 +118,13 @@ func WithEndpoint(t string) Option {
 }
 
 // WithRegion sets the AWS region for the store.
-// This defaults to "us-east-1", and cannot be overridden by an empty string.
+// This defaults to the region of the profile if WithProfile() is supplied, otherwise to "us-east-1", and cannot be
+// overridden by an empty string.
 func WithRegion(t string) Option {
 	return optionFunc(func(o *options) {
 		if t != "" {
 			o.region = t
+			o.regionSet = true
 		}
 	})
 }
@@ -114,7 +154,7 @@ func WithCredentialsID(t string) Option {
 // WithCredentialsSecret sets the credentials secret.
 func WithCredentialsSecret(t string) Option {
 	return optionFunc(func(o *options) {
-		o.credentialsSecret = t
+		o.credentialsSecret = redactedString(t)
 	})
 }
 
@@ -122,134 +162,286 @@ func WithCredentialsSecret(t string) Option {
 func WithForcePathStyle(t bool) Option {
 	return optionFunc(func(o *options) {
 		o.forcePathStyle = t
+		o.forcePathStyleSet = true
+	})
+}
+
+// WithTracerProvider sets the OpenTelemetry tracer provider for the store.
+// If not supplied the store will not generate traces.
+func WithTracerProvider(t trace.TracerProvider) Option {
+	return optionFunc(func(o *options) {
+		if t != nil {
+			o.tracerProvider = t
+		}
+	})
+}
+
+// WithLogger sets the logger for the store.
+// If not supplied the store will not log.  Passphrases, credentials and object contents are never logged.
+func WithLogger(t zerolog.Logger) Option {
+	return optionFunc(func(o *options) {
+		o.logger = t
+	})
+}
+
+// WithRetryPolicy sets the policy for retrying failed requests to S3.
+// If not supplied the AWS SDK's default retry behaviour is used.
+func WithRetryPolicy(t RetryPolicy) Option {
+	return optionFunc(func(o *options) {
+		o.retryPolicy = &t
+	})
+}
+
+// WithMaxConcurrency sets the maximum number of concurrent downloads when retrieving multiple objects.
+// The store reduces its concurrency below this if S3 throttles requests.
+// This defaults to 64, and cannot be set below 1.
+func WithMaxConcurrency(t int) Option {
+	return optionFunc(func(o *options) {
+		if t > 0 {
+			o.maxConcurrency = t
+		}
 	})
 }
 
 // Store is the store for the wallet held encrypted on Amazon S3.
 type Store struct {
-	session    *session.Session
-	id         []byte
-	bucket     string
-	path       string
-	passphrase []byte
+	client           s3iface.S3API
+	tracer           trace.Tracer
+	log              zerolog.Logger
+	retryer          *retryer
+	limiter          *limiter
+	id               []byte
+	region           string
+	endpoint         string
+	forcePathStyle   bool
+	provider         Provider
+	providerProfile  *providerProfile
+	bucket           string
+	path             string
+	passphrase       redacted
+	readOnly         bool
+	objectLock       *ObjectLock
+	objectAttributes map[ObjectKind]*ObjectAttributes
+	manifest         *Manifest
 }
 
 // New creates a new Amazon S3-compatible store.
 // This takes the following options:
-//   - region: a string specifying the Amazon S3 region, defaults to "us-east-1", set with WithRegion()
+//   - region: a string specifying the Amazon S3 region, defaults to the profile's region or "us-east-1", set with WithRegion()
 //   - id: a byte array specifying an identifying key for the store, defaults to nil, set with WithID()
 //   - passphrase: a key used to encrypt all data written to the store, defaults to blank and no additional encryption
-//   - bucket: the name of a bucket to create, defaults to one generated using the credentials and ID
+//   - bucket: the name of a bucket to create, defaults to one generated using the AWS account and ID
 //   - path: a path inside the bucket in which to place wallets, defaults to the root of the bucket
 //   - endpoint: a URL for an S3-compatible service to use in place of S3 itself
 //   - credentials ID: AWS access credentials ID
 //   - credentials secret: AWS access credentials secret
+//   - credentials session token: AWS session token for temporary access credentials
+//   - credentials provider: a provider of AWS access credentials, in place of the credentials ID and secret
+//   - profile: a named profile in the shared AWS configuration
+//   - assume role: the ARN of a role to assume, optionally with an external ID and session name
+//   - web identity token file: a web identity token to exchange for the credentials of the role to assume
+//   - HTTP client: an HTTP client for all requests, set with WithHTTPClient()
+//   - CA bundle, client certificate, proxy and connection pool: settings for the HTTP client if one is not supplied
+//   - session: an existing AWS session to use in place of creating one, set with WithSession()
+//   - S3 client: an existing S3 client to use in place of creating one, set with WithS3Client()
+//   - tracer provider: an OpenTelemetry tracer provider, defaults to no tracing, set with WithTracerProvider()
+//   - logger: a zerolog logger, defaults to no logging, set with WithLogger()
+//   - retry policy: the policy for retrying failed requests, defaults to the AWS SDK's policy, set with WithRetryPolicy()
+//   - max concurrency: the maximum number of concurrent downloads, defaults to 64, set with WithMaxConcurrency()
+//   - bucket provisioning: security settings applied to a bucket when it is created, set with WithBucketProvisioning()
+//   - object lock: S3 Object Lock retention and legal holds applied to wallets and accounts, set with WithObjectLock()
+//   - object attributes: the storage class, tags and metadata of each kind of object, set with WithObjectAttributes()
+//   - audit on open: whether to audit the security settings of the bucket, defaults to no, set with WithAuditOnOpen()
+//   - provider: the provider of the S3-compatible service, defaults to inferred from the endpoint, set with WithProvider()
+//   - read only: whether the store is read-only, defaults to false, set with WithReadOnly()
+//   - create: whether to create the bucket and path if they do not exist, defaults to true, set with WithCreate()
+//
+// If a bucket is not supplied, one is generated from the AWS account and ID (or, for S3-compatible services, the
+// credentials ID and ID).  A bucket previously generated from the credentials ID and ID is used if present, and can
+// be migrated with WithLegacyBucketMigration().  If no existing bucket is found New returns ErrBucketNotFound unless
+// WithCreateDerivedBucket() is set.
+//
+// New reads the store's manifest, creating it for a new store, and returns ErrIncompatibleStore if the store cannot
+// be used with the supplied options.
 //
 // If credentials are not supplied, the access credentials should be in a standard place, e.g. ~/.aws/credentials .
+// Temporary credentials, such as those for an assumed role, are refreshed automatically before they expire.
 func New(opts ...Option) (wtypes.Store, error) {
 	options := options{
-		region: "us-east-1",
+		region:                defaultRegion,
+		assumeRoleSessionName: defaultRoleSessionName,
+		tracerProvider:        noop.NewTracerProvider(),
+		logger:                zerolog.Nop(),
+		maxConcurrency:        downloadConcurrency,
+		create:                true,
 	}
 	for _, o := range opts {
 		o.apply(&options)
 	}
-
-	sessionConfig := &aws.Config{
-		Region:           aws.String(options.region),
-		Endpoint:         aws.String(options.endpoint),
-		S3ForcePathStyle: aws.Bool(options.forcePathStyle),
+	if options.readOnly {
+		options.create = false
+	}
+	if !options.create && options.legacyBucketMigration {
+		return nil, errors.New("cannot migrate a legacy bucket without creating the store")
+	}
+	if err := checkObjectAttributes(options.objectAttributes); err != nil {
+		return nil, err
+	}
+	if options.objectLock != nil {
+		if err := checkObjectLock(options.objectLock); err != nil {
+			return nil, err
+		}
 	}
 
-	if len(options.credentialsID) > 0 {
-		sessionConfig.Credentials = credentials.NewStaticCredentials(options.credentialsID, options.credentialsSecret, "")
+	var retryer *retryer
+	reqOpts := make([]request.Option, 0)
+	if options.retryPolicy != nil {
+		retryer = newRetryer(*options.retryPolicy)
+		reqOpts = append(reqOpts, withRetryer(retryer))
+	}
+	if options.readOnly {
+		reqOpts = append(reqOpts, withReadOnlyGuard())
 	}
 
-	session, err := session.NewSession(sessionConfig)
-	if err != nil {
+	if err := applyProvider(&options); err != nil {
 		return nil, err
 	}
 
-	creds, err := session.Config.Credentials.Get()
+	conn, session, err := s3Client(&options)
 	if err != nil {
 		return nil, err
 	}
+	options.providerProfile = resolveProvider(&options)
+	reqOpts = append(reqOpts, withProviderCompatibility(options.providerProfile))
 
-	bucket := ""
+	ctx := context.Background()
+	var bucket string
+	var exists bool
 	if options.bucket != "" {
 		if len(options.bucket) > 63 {
 			return nil, errors.New("bucket cannot be more than 63 characters in length")
 		}
 		bucket = options.bucket
-	} else {
-		// Generate a bucket name from the cryptKey.  This will be the SHA256 hash of a
-		// string unique to the account, as a hex string of 63 charaters (as S3 only
-		// allows bucket names up to 63 characters in length).
-		hash := util.SHA256([]byte(fmt.Sprintf("Ethereum 2 wallet:%s", creds.AccessKeyID)), options.id)
-		bucket = hex.EncodeToString(hash)[:63]
-	}
-
-	// Check the bucket exists; if not create it.
-	conn := s3.New(session)
-	_, err = conn.GetBucketAcl(&s3.GetBucketAclInput{Bucket: &bucket})
-	if err != nil {
-		if !strings.Contains(err.Error(), "NoSuchBucket") {
-			return nil, errors.Wrap(err, "unable to access bucket")
+		if options.create {
+			exists, err = bucketExists(ctx, conn, bucket, options.providerProfile, reqOpts)
+			if err != nil {
+				return nil, err
+			}
 		}
-		// Create the bucket
-		_, err = conn.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)})
+	} else {
+		bucket, exists, err = discoverBucket(ctx, session, conn, &options, reqOpts)
 		if err != nil {
-			return nil, errors.Wrap(err, "unable to create bucket")
+			return nil, err
 		}
-		err = conn.WaitUntilBucketExists(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to confirm bucket creation")
+		if !exists {
+			if !options.create {
+				return nil, &NotFoundError{Bucket: bucket}
+			}
+			if !options.createDerivedBucket {
+				return nil, errors.Wrap(ErrBucketNotFound, "supply a bucket, or allow creation of a new bucket")
+			}
 		}
 	}
 
+	log := options.logger.With().Str("bucket", bucket).Logger()
+
 	// Remove leading / from path if present.
 	options.path = strings.TrimPrefix(options.path, "/")
 
+	if options.create {
+		if err := provisionStore(ctx, conn, bucket, exists, &options, log, reqOpts); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := verifyStore(ctx, conn, bucket, strings.TrimSuffix(options.path, "/"), reqOpts); err != nil {
+			return nil, err
+		}
+	}
+
+	store := &Store{
+		client:           conn,
+		tracer:           options.tracerProvider.Tracer(tracerName),
+		log:              log,
+		retryer:          retryer,
+		limiter:          newLimiter(options.maxConcurrency),
+		id:               options.id,
+		region:           options.region,
+		endpoint:         options.endpoint,
+		forcePathStyle:   options.forcePathStyle,
+		provider:         options.provider,
+		providerProfile:  options.providerProfile,
+		bucket:           bucket,
+		path:             options.path,
+		passphrase:       options.passphrase,
+		readOnly:         options.readOnly,
+		objectLock:       options.objectLock,
+		objectAttributes: options.objectAttributes,
+	}
+
+	if !options.migrating {
+		if err := store.loadManifest(ctx, options.create); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.auditMode != AuditModeNone {
+		if err := store.auditOnOpen(ctx, options.auditMode); err != nil {
+			return nil, err
+		}
+	}
+
+	return store, nil
+}
+
+// provisionStore creates the bucket and path of the store if they do not exist.
+func provisionStore(ctx context.Context,
+	conn s3iface.S3API,
+	bucket string,
+	bucketExists bool,
+	options *options,
+	log zerolog.Logger,
+	reqOpts []request.Option,
+) error {
+	if !bucketExists {
+		log.Debug().Msg("Bucket does not exist; creating")
+		if err := createBucket(ctx, conn, bucket, options, log, reqOpts); err != nil {
+			return err
+		}
+		log.Info().Msg("Created bucket")
+	}
+
 	// Check the path exists; if not create it.
 	pathElements := strings.Split(options.path, "/")
-	path := ""
+	elementPath := ""
 	for _, pathElement := range pathElements {
 		if len(pathElement) == 0 {
 			continue
 		}
-		path = filepath.Join(path, pathElement)
-		_, err := conn.GetObject(&s3.GetObjectInput{
+		elementPath = filepath.Join(elementPath, pathElement)
+		marker := fmt.Sprintf("%s/", elementPath)
+		_, err := conn.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
 			Bucket: aws.String(bucket),
-			Key:    aws.String(path),
-		})
+			Key:    aws.String(marker),
+		}, reqOpts...)
 		if err != nil {
-			if !strings.Contains(err.Error(), "NoSuchKey") {
-				return nil, errors.Wrap(err, "unable to access path")
+			if !isKeyNotFound(err) {
+				return errors.Wrap(err, "unable to access path")
 			}
-			_, err := conn.PutObject(&s3.PutObjectInput{
+			_, err := conn.PutObjectWithContext(ctx, &s3.PutObjectInput{
 				Bucket: aws.String(bucket),
-				Key:    aws.String(fmt.Sprintf("%s/", path)),
-			})
+				Key:    aws.String(marker),
+			}, reqOpts...)
 			if err != nil {
-				return nil, errors.Wrap(err, "failed to confirm path creation")
+				return errors.Wrap(err, "failed to confirm path creation")
 			}
+			log.Debug().Str("path", elementPath).Msg("Created path")
 		}
 	}
 
-	return &Store{
-		session:    session,
-		id:         options.id,
-		bucket:     bucket,
-		path:       options.path,
-		passphrase: options.passphrase,
-	}, nil
+	return nil
 }
 
 // Name returns the name of this store.
 func (s *Store) Name() string {
 	return "s3"
 }
-
-// Location returns the location of this store.
-func (s *Store) Location() string {
-	return filepath.Join(s.bucket, s.path)
-}
@@ -14,17 +14,39 @@
 package s3_test
 
 import (
+	"encoding/hex"
 	"fmt"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	util "github.com/wealdtech/go-eth2-util"
 	s3 "github.com/wealdtech/go-eth2-wallet-store-s3"
 	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
 )
 
+// accountBucket returns the name of the bucket generated for the AWS account of the test credentials.
+func accountBucket(t *testing.T, id []byte) string {
+	t.Helper()
+
+	sess, err := session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials(os.Getenv("S3_CREDENTIALS_ID"), os.Getenv("S3_CREDENTIALS_SECRET"), ""),
+	})
+	require.NoError(t, err)
+	identity, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	require.NoError(t, err)
+	hash := util.SHA256([]byte(fmt.Sprintf("Ethereum 2 wallet account:%s", aws.StringValue(identity.Account))), id)
+
+	return hex.EncodeToString(hash)[:63]
+}
+
 func TestNew(t *testing.T) {
 	if os.Getenv("S3_CREDENTIALS_ID") == "" ||
 		os.Getenv("S3_CREDENTIALS_SECRET") == "" {
@@ -34,14 +56,18 @@ func TestNew(t *testing.T) {
 	store, err := s3.New(
 		s3.WithCredentialsID(os.Getenv("S3_CREDENTIALS_ID")),
 		s3.WithCredentialsSecret(os.Getenv("S3_CREDENTIALS_SECRET")),
+		s3.WithCreateDerivedBucket(true),
 	)
 	if err != nil {
+		// A missing bucket is created, so should not cause the test to be skipped.
+		require.NotErrorIs(t, err, s3.ErrBucketNotFound)
 		t.Skip("unable to access S3; skipping test")
 	}
 	assert.Equal(t, "s3", store.Name())
 	store, err = s3.New(
 		s3.WithCredentialsID(os.Getenv("S3_CREDENTIALS_ID")),
 		s3.WithCredentialsSecret(os.Getenv("S3_CREDENTIALS_SECRET")),
+		s3.WithCreateDerivedBucket(true),
 		s3.WithRegion("us-west-1"),
 		s3.WithID([]byte("west")),
 	)
@@ -50,6 +76,7 @@ func TestNew(t *testing.T) {
 	store, err = s3.New(
 		s3.WithCredentialsID(os.Getenv("S3_CREDENTIALS_ID")),
 		s3.WithCredentialsSecret(os.Getenv("S3_CREDENTIALS_SECRET")),
+		s3.WithCreateDerivedBucket(true),
 		s3.WithRegion("us-west-1"),
 		s3.WithID([]byte("west")),
 		s3.WithPassphrase([]byte("secret")),
@@ -59,7 +86,7 @@ func TestNew(t *testing.T) {
 
 	storeLocationProvider, ok := store.(wtypes.StoreLocationProvider)
 	assert.True(t, ok)
-	assert.Equal(t, "eb496be46d940d33068bdac263d7b990de5f99db68abeaae002d9d7d62c26b6", storeLocationProvider.Location())
+	assert.Equal(t, fmt.Sprintf("s3://%s?region=us-west-1", accountBucket(t, []byte("west"))), storeLocationProvider.Location())
 }
 
 func TestNewOptions(t *testing.T) {
@@ -80,8 +107,9 @@ func TestNewOptions(t *testing.T) {
 			opts: []s3.Option{
 				s3.WithCredentialsID(os.Getenv("S3_CREDENTIALS_ID")),
 				s3.WithCredentialsSecret(os.Getenv("S3_CREDENTIALS_SECRET")),
+				s3.WithCreateDerivedBucket(true),
 			},
-			location: "6f6765e9f7c404448be63ccb049b3f95b4a95e1ff87b57c4d20c64b579f11f0",
+			location: fmt.Sprintf("s3://%s", accountBucket(t, nil)),
 		},
 		{
 			name: "SpecificBucket",
@@ -90,7 +118,7 @@ func TestNewOptions(t *testing.T) {
 				s3.WithCredentialsSecret(os.Getenv("S3_CREDENTIALS_SECRET")),
 				s3.WithBucket(fmt.Sprintf("testnewoptions-specificbucket-%d", ts)),
 			},
-			location: fmt.Sprintf("testnewoptions-specificbucket-%d", ts),
+			location: fmt.Sprintf("s3://testnewoptions-specificbucket-%d", ts),
 		},
 		{
 			name: "SpecificPath",
@@ -100,7 +128,7 @@ func TestNewOptions(t *testing.T) {
 				s3.WithBucket(fmt.Sprintf("testnewoptions-specificpath-%d", ts)),
 				s3.WithPath("a/b/c"),
 			},
-			location: fmt.Sprintf("testnewoptions-specificpath-%d/a/b/c", ts),
+			location: fmt.Sprintf("s3://testnewoptions-specificpath-%d/a/b/c", ts),
 		},
 	}
 
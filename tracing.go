@@ -0,0 +1,70 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the name of the tracer used by the store.
+const tracerName = "github.com/wealdtech/go-eth2-wallet-store-s3"
+
+// startSpan starts a span with the given name and attributes.
+func (s *Store) startSpan(ctx context.Context,
+	name string,
+	attrs ...attribute.KeyValue,
+) (
+	context.Context,
+	trace.Span,
+) {
+	tracer := s.tracer
+	if tracer == nil {
+		// Stores created without New() do not have a tracer.
+		tracer = noop.NewTracerProvider().Tracer(tracerName)
+	}
+
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends a span, recording the error if present.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// withRequestIDs is a request option that annotates the span with
+// the S3 request IDs of each request made.
+func withRequestIDs(span trace.Span) request.Option {
+	return func(r *request.Request) {
+		r.Handlers.Complete.PushBack(func(r *request.Request) {
+			if r.RequestID != "" {
+				span.SetAttributes(attribute.String("aws.request_id", r.RequestID))
+			}
+			if r.HTTPResponse != nil {
+				if extendedRequestID := r.HTTPResponse.Header.Get("X-Amz-Id-2"); extendedRequestID != "" {
+					span.SetAttributes(attribute.String("aws.extended_request_id", extendedRequestID))
+				}
+			}
+		})
+	}
+}
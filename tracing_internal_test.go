@@ -0,0 +1,194 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestDecryptSpan(t *testing.T) {
+	tests := []struct {
+		name   string
+		store  *Store
+		data   []byte
+		status codes.Code
+	}{
+		{
+			name:   "NoPassphrase",
+			store:  &Store{},
+			data:   []byte(`{"test":true}`),
+			status: codes.Unset,
+		},
+		{
+			name: "ShortData",
+			store: &Store{
+				passphrase: []byte("test passphrase"),
+			},
+			data:   []byte(`{"test":true}`),
+			status: codes.Error,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			test.store.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer(tracerName)
+
+			_, _ = test.store.decrypt(context.Background(), "wallet/account", test.data)
+
+			spans := recorder.Ended()
+			require.Len(t, spans, 1)
+			require.Equal(t, "decryptIfRequired", spans[0].Name())
+			require.Contains(t, spans[0].Attributes(), attribute.String("aws.s3.key", "wallet/account"))
+			require.Equal(t, test.status, spans[0].Status().Code)
+		})
+	}
+}
+
+func TestNoTracer(t *testing.T) {
+	store := &Store{}
+	_, span := store.startSpan(context.Background(), "test")
+	require.False(t, span.IsRecording())
+	endSpan(span, nil)
+}
+
+// newTracedStore returns a store backed by an in-memory S3 service that records its spans.
+func newTracedStore(t *testing.T) (*memoryS3, *Store, *tracetest.SpanRecorder) {
+	t.Helper()
+
+	m, client := newMemoryS3(t)
+	recorder := tracetest.NewSpanRecorder()
+	store, err := New(WithS3Client(client),
+		WithBucket("bucket"),
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+	)
+	require.NoError(t, err)
+
+	return m, store.(*Store), recorder
+}
+
+// endedSpans returns the ended spans with the given name.
+func endedSpans(recorder *tracetest.SpanRecorder, name string) []sdktrace.ReadOnlySpan {
+	spans := make([]sdktrace.ReadOnlySpan, 0)
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			spans = append(spans, span)
+		}
+	}
+
+	return spans
+}
+
+// spanAttribute returns the value of the given attribute of a span, or an empty string if it is not present.
+func spanAttribute(span sdktrace.ReadOnlySpan, key attribute.Key) string {
+	for _, attr := range span.Attributes() {
+		if attr.Key == key {
+			return attr.Value.Emit()
+		}
+	}
+
+	return ""
+}
+
+// requireRequestSpan requires that a span describes a request to the store's bucket that has completed.
+func requireRequestSpan(t *testing.T, span sdktrace.ReadOnlySpan, parent sdktrace.ReadOnlySpan) {
+	t.Helper()
+
+	require.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	require.Equal(t, "bucket", spanAttribute(span, "aws.s3.bucket"))
+	require.NotEmpty(t, spanAttribute(span, "aws.request_id"))
+	require.NotEmpty(t, spanAttribute(span, "aws.extended_request_id"))
+}
+
+func TestStoreAccountSpans(t *testing.T) {
+	_, s, recorder := newTracedStore(t)
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+	accountID := uuid.New()
+	require.NoError(t, s.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"account"}`, accountID))))
+
+	spans := endedSpans(recorder, "StoreAccount")
+	require.Len(t, spans, 1)
+	parent := spans[0]
+	require.Equal(t, walletID.String(), spanAttribute(parent, "wallet_id"))
+	require.Equal(t, accountID.String(), spanAttribute(parent, "account_id"))
+
+	found := false
+	for _, span := range endedSpans(recorder, "Upload") {
+		if spanAttribute(span, "aws.s3.key") == s.accountPath(walletID, accountID) {
+			requireRequestSpan(t, span, parent)
+			found = true
+		}
+	}
+	require.True(t, found)
+}
+
+func TestRetrieveAccountsSpans(t *testing.T) {
+	m, s, recorder := newTracedStore(t)
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+	accountIDs := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		accountID := uuid.New()
+		require.NoError(t, s.StoreAccount(walletID, accountID, []byte(fmt.Sprintf(`{"uuid":%q,"name":"account %d"}`, accountID, i))))
+		accountIDs[s.accountPath(walletID, accountID)] = true
+	}
+
+	// Force the listing over multiple pages.
+	m.setPageSize(2)
+	retrieved := 0
+	for range s.RetrieveAccounts(walletID) {
+		retrieved++
+	}
+	require.Equal(t, len(accountIDs), retrieved)
+
+	spans := endedSpans(recorder, "RetrieveAccounts")
+	require.Len(t, spans, 1)
+	parent := spans[0]
+	require.Equal(t, walletID.String(), spanAttribute(parent, "wallet_id"))
+
+	// Each page of the listing has its own span.
+	pages := 0
+	for _, span := range endedSpans(recorder, "ListObjectsV2") {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			continue
+		}
+		requireRequestSpan(t, span, parent)
+		require.Equal(t, s.walletPath(walletID)+"/", spanAttribute(span, "aws.s3.prefix"))
+		pages++
+	}
+	require.Greater(t, pages, 1)
+
+	downloads := 0
+	for _, span := range endedSpans(recorder, "Download") {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			continue
+		}
+		requireRequestSpan(t, span, parent)
+		require.True(t, accountIDs[spanAttribute(span, "aws.s3.key")])
+		downloads++
+	}
+	require.Equal(t, len(accountIDs), downloads)
+}
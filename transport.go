@@ -0,0 +1,148 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ConnectionPool defines the pool of connections used to access S3.
+// Zero values leave the defaults of Go's standard HTTP transport in place.
+type ConnectionPool struct {
+	// MaxIdleConns is the maximum number of idle connections across all hosts.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is the maximum number of idle connections to each host.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost is the maximum number of connections to each host.
+	MaxConnsPerHost int
+	// IdleConnTimeout is the time after which an idle connection is closed.
+	IdleConnTimeout time.Duration
+}
+
+// WithHTTPClient sets the HTTP client used for all requests made by the store.
+// This cannot be used alongside WithCABundle(), WithClientCertificate(), WithProxy() or WithConnectionPool(),
+// which should be configured on the supplied client instead.
+func WithHTTPClient(t *http.Client) Option {
+	return optionFunc(func(o *options) {
+		o.httpClient = t
+	})
+}
+
+// WithCABundle sets PEM-encoded certificates of additional certificate authorities to trust, for example
+// the private certificate authority of an on-premises S3-compatible service.
+func WithCABundle(t []byte) Option {
+	return optionFunc(func(o *options) {
+		o.caBundle = t
+	})
+}
+
+// WithClientCertificate sets the certificate presented by the store for mutual TLS.
+func WithClientCertificate(t tls.Certificate) Option {
+	return optionFunc(func(o *options) {
+		o.clientCertificate = &t
+	})
+}
+
+// WithProxy sets the URL of the proxy through which requests are made.
+// If not supplied the proxy is taken from the environment, as per http.ProxyFromEnvironment().
+func WithProxy(t string) Option {
+	return optionFunc(func(o *options) {
+		o.proxy = t
+	})
+}
+
+// WithConnectionPool sets the parameters of the pool of connections used to access S3.
+func WithConnectionPool(t ConnectionPool) Option {
+	return optionFunc(func(o *options) {
+		o.connectionPool = &t
+	})
+}
+
+// httpClient returns the HTTP client specified by the options.
+// It returns nil if no options require a custom client, in which case the AWS SDK's default client is used.
+func httpClient(options *options) (*http.Client, error) {
+	custom := len(options.caBundle) > 0 ||
+		options.clientCertificate != nil ||
+		options.proxy != "" ||
+		options.connectionPool != nil
+	if options.httpClient != nil {
+		if custom {
+			return nil, errors.New("cannot supply an HTTP client alongside TLS, proxy or connection pool options")
+		}
+
+		return options.httpClient, nil
+	}
+	if !custom {
+		return nil, nil
+	}
+
+	transport, isTransport := http.DefaultTransport.(*http.Transport)
+	if !isTransport {
+		return nil, errors.New("default HTTP transport is not configurable")
+	}
+	transport = transport.Clone()
+
+	if len(options.caBundle) > 0 || options.clientCertificate != nil {
+		tlsConfig := &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		}
+		if len(options.caBundle) > 0 {
+			rootCAs, err := x509.SystemCertPool()
+			if err != nil {
+				rootCAs = x509.NewCertPool()
+			}
+			if !rootCAs.AppendCertsFromPEM(options.caBundle) {
+				return nil, errors.New("no certificates found in CA bundle")
+			}
+			tlsConfig.RootCAs = rootCAs
+		}
+		if options.clientCertificate != nil {
+			tlsConfig.Certificates = []tls.Certificate{*options.clientCertificate}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if options.proxy != "" {
+		proxyURL, err := url.Parse(options.proxy)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid proxy URL")
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if options.connectionPool != nil {
+		if options.connectionPool.MaxIdleConns > 0 {
+			transport.MaxIdleConns = options.connectionPool.MaxIdleConns
+		}
+		if options.connectionPool.MaxIdleConnsPerHost > 0 {
+			transport.MaxIdleConnsPerHost = options.connectionPool.MaxIdleConnsPerHost
+		}
+		if options.connectionPool.MaxConnsPerHost > 0 {
+			transport.MaxConnsPerHost = options.connectionPool.MaxConnsPerHost
+		}
+		if options.connectionPool.IdleConnTimeout > 0 {
+			transport.IdleConnTimeout = options.connectionPool.IdleConnTimeout
+		}
+	}
+
+	return &http.Client{
+		Transport: transport,
+	}, nil
+}
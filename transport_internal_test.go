@@ -0,0 +1,156 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPClient(t *testing.T) {
+	supplied := &http.Client{}
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		err    string
+		client *http.Client
+		check  func(t *testing.T, transport *http.Transport)
+	}{
+		{
+			name: "Default",
+		},
+		{
+			name:   "Supplied",
+			opts:   []Option{WithHTTPClient(supplied)},
+			client: supplied,
+		},
+		{
+			name: "SuppliedWithProxy",
+			opts: []Option{
+				WithHTTPClient(supplied),
+				WithProxy("http://proxy.example.com:3128"),
+			},
+			err: "cannot supply an HTTP client alongside TLS, proxy or connection pool options",
+		},
+		{
+			name: "BadCABundle",
+			opts: []Option{WithCABundle([]byte("not a certificate"))},
+			err:  "no certificates found in CA bundle",
+		},
+		{
+			name: "BadProxy",
+			opts: []Option{WithProxy("http://proxy example.com")},
+			err:  `invalid proxy URL: parse "http://proxy example.com": invalid character " " in host name`,
+		},
+		{
+			name: "Proxy",
+			opts: []Option{WithProxy("http://proxy.example.com:3128")},
+			check: func(t *testing.T, transport *http.Transport) {
+				t.Helper()
+				req, err := http.NewRequest(http.MethodGet, "https://s3.amazonaws.com/", nil)
+				require.NoError(t, err)
+				proxyURL, err := transport.Proxy(req)
+				require.NoError(t, err)
+				require.Equal(t, "http://proxy.example.com:3128", proxyURL.String())
+			},
+		},
+		{
+			name: "ConnectionPool",
+			opts: []Option{WithConnectionPool(ConnectionPool{
+				MaxIdleConns:        10,
+				MaxIdleConnsPerHost: 5,
+				MaxConnsPerHost:     20,
+				IdleConnTimeout:     time.Minute,
+			})},
+			check: func(t *testing.T, transport *http.Transport) {
+				t.Helper()
+				require.Equal(t, 10, transport.MaxIdleConns)
+				require.Equal(t, 5, transport.MaxIdleConnsPerHost)
+				require.Equal(t, 20, transport.MaxConnsPerHost)
+				require.Equal(t, time.Minute, transport.IdleConnTimeout)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			options := options{}
+			for _, opt := range test.opts {
+				opt.apply(&options)
+			}
+			client, err := httpClient(&options)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			if test.check == nil {
+				require.Equal(t, test.client, client)
+				return
+			}
+			transport, isTransport := client.Transport.(*http.Transport)
+			require.True(t, isTransport)
+			test.check(t, transport)
+		})
+	}
+}
+
+func TestHTTPClientTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = &tls.Config{
+		ClientAuth: tls.RequestClientCert,
+		MinVersion: tls.VersionTLS12,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	// The test server's certificate doubles as a client certificate.
+	clientCertificate := srv.TLS.Certificates[0]
+
+	// Without the CA bundle the server is not trusted.
+	client, err := httpClient(&options{clientCertificate: &clientCertificate})
+	require.NoError(t, err)
+	_, err = client.Get(srv.URL)
+	require.Error(t, err)
+
+	// With the CA bundle but without a client certificate the server rejects the request.
+	client, err = httpClient(&options{caBundle: caBundle})
+	require.NoError(t, err)
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// With both the request succeeds.
+	client, err = httpClient(&options{caBundle: caBundle, clientCertificate: &clientCertificate})
+	require.NoError(t, err)
+	resp, err = client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,121 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+// urlScheme is the scheme for store URLs.
+const urlScheme = "s3"
+
+// NewFromURL creates a new Amazon S3-compatible store from a URL of the form
+//
+//	s3://bucket/path?region=eu-west-1&endpoint=https://s3.example.com&pathstyle=true
+//
+// The bucket may be omitted, as in s3:///path, in which case it is generated as per New().
+// The following query parameters are supported:
+//   - region: the Amazon S3 region, as per WithRegion()
+//   - endpoint: a URL for an S3-compatible service, as per WithEndpoint()
+//   - pathstyle: true to use path-style addressing, as per WithForcePathStyle()
+//   - provider: the provider of the S3-compatible service, as per WithProvider()
+//
+// Sensitive information such as passphrases and credentials cannot be supplied in the URL; they should be
+// supplied as additional options, which take precedence over the values in the URL.
+func NewFromURL(storeURL string, opts ...Option) (wtypes.Store, error) {
+	urlOpts, err := parseURL(storeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(append(urlOpts, opts...)...)
+}
+
+// parseURL parses a store URL into options.
+func parseURL(storeURL string) ([]Option, error) {
+	u, err := url.Parse(storeURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid store URL")
+	}
+	if u.Scheme != urlScheme {
+		return nil, fmt.Errorf("store URL scheme must be %q", urlScheme)
+	}
+	if u.User != nil {
+		return nil, errors.New("store URL cannot contain credentials")
+	}
+
+	opts := []Option{
+		WithBucket(u.Host),
+		WithPath(strings.TrimPrefix(u.Path, "/")),
+	}
+	for key, values := range u.Query() {
+		if len(values) != 1 {
+			return nil, fmt.Errorf("store URL parameter %q must have a single value", key)
+		}
+		value := values[0]
+		switch key {
+		case "region":
+			opts = append(opts, WithRegion(value))
+		case "endpoint":
+			opts = append(opts, WithEndpoint(value))
+		case "pathstyle":
+			forcePathStyle, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %q for store URL parameter %q", value, key)
+			}
+			opts = append(opts, WithForcePathStyle(forcePathStyle))
+		case "provider":
+			opts = append(opts, WithProvider(Provider(value)))
+		default:
+			return nil, fmt.Errorf("unknown store URL parameter %q", key)
+		}
+	}
+
+	return opts, nil
+}
+
+// Location returns the location of this store, as a URL that can be passed to NewFromURL().
+func (s *Store) Location() string {
+	u := &url.URL{
+		Scheme: urlScheme,
+		Host:   s.bucket,
+	}
+	if s.path != "" {
+		u.Path = "/" + s.path
+	}
+
+	query := url.Values{}
+	// The default region is omitted, unless the provider would otherwise use a region of its own.
+	if s.region != "" && (s.region != defaultRegion || providerProfiles()[s.provider].region != "") {
+		query.Set("region", s.region)
+	}
+	if s.endpoint != "" {
+		query.Set("endpoint", s.endpoint)
+	}
+	if s.forcePathStyle {
+		query.Set("pathstyle", "true")
+	}
+	if s.provider != "" {
+		query.Set("provider", string(s.provider))
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String()
+}
@@ -0,0 +1,179 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		err      string
+		expected options
+	}{
+		{
+			name: "Invalid",
+			url:  "s3://bucket/%zz",
+			err:  `invalid store URL: parse "s3://bucket/%zz": invalid URL escape "%zz"`,
+		},
+		{
+			name: "WrongScheme",
+			url:  "https://bucket/path",
+			err:  `store URL scheme must be "s3"`,
+		},
+		{
+			name: "Credentials",
+			url:  "s3://id:secret@bucket/path",
+			err:  "store URL cannot contain credentials",
+		},
+		{
+			name: "UnknownParameter",
+			url:  "s3://bucket?passphrase=secret",
+			err:  `unknown store URL parameter "passphrase"`,
+		},
+		{
+			name: "MultipleValues",
+			url:  "s3://bucket?region=eu-west-1&region=eu-west-2",
+			err:  `store URL parameter "region" must have a single value`,
+		},
+		{
+			name: "InvalidPathStyle",
+			url:  "s3://bucket?pathstyle=maybe",
+			err:  `invalid value "maybe" for store URL parameter "pathstyle"`,
+		},
+		{
+			name: "BucketOnly",
+			url:  "s3://bucket",
+			expected: options{
+				region: defaultRegion,
+				bucket: "bucket",
+			},
+		},
+		{
+			name: "NoBucket",
+			url:  "s3:///a/b",
+			expected: options{
+				region: defaultRegion,
+				path:   "a/b",
+			},
+		},
+		{
+			name: "Full",
+			url:  "s3://bucket/a/b?region=eu-west-1&endpoint=https%3A%2F%2Fminio.example.com%3A9000&pathstyle=true&provider=minio",
+			expected: options{
+				region:            "eu-west-1",
+				regionSet:         true,
+				endpoint:          "https://minio.example.com:9000",
+				forcePathStyle:    true,
+				forcePathStyleSet: true,
+				provider:          ProviderMinIO,
+				bucket:            "bucket",
+				path:              "a/b",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts, err := parseURL(test.url)
+			if test.err != "" {
+				require.EqualError(t, err, test.err)
+			} else {
+				require.NoError(t, err)
+				res := options{
+					region: defaultRegion,
+				}
+				for _, opt := range opts {
+					opt.apply(&res)
+				}
+				require.Equal(t, test.expected, res)
+			}
+		})
+	}
+}
+
+func TestLocationRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		store    *Store
+		location string
+	}{
+		{
+			name: "Defaults",
+			store: &Store{
+				region: defaultRegion,
+				bucket: "bucket",
+			},
+			location: "s3://bucket",
+		},
+		{
+			name: "Path",
+			store: &Store{
+				region: defaultRegion,
+				bucket: "bucket",
+				path:   "a/b/c",
+			},
+			location: "s3://bucket/a/b/c",
+		},
+		{
+			name: "Full",
+			store: &Store{
+				region:         "eu-west-1",
+				endpoint:       "https://minio.example.com:9000",
+				forcePathStyle: true,
+				provider:       ProviderMinIO,
+				bucket:         "bucket",
+				path:           "a/b",
+				passphrase:     []byte("secret"),
+			},
+			location: "s3://bucket/a/b?endpoint=https%3A%2F%2Fminio.example.com%3A9000&pathstyle=true&provider=minio&region=eu-west-1",
+		},
+		{
+			name: "ProviderDefaultRegion",
+			store: &Store{
+				region:   defaultRegion,
+				endpoint: "https://account.r2.cloudflarestorage.com",
+				provider: ProviderR2,
+				bucket:   "bucket",
+			},
+			location: "s3://bucket?endpoint=https%3A%2F%2Faccount.r2.cloudflarestorage.com&provider=r2&region=us-east-1",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			location := test.store.Location()
+			require.Equal(t, test.location, location)
+
+			opts, err := parseURL(location)
+			require.NoError(t, err)
+			res := options{
+				region: defaultRegion,
+			}
+			for _, opt := range opts {
+				opt.apply(&res)
+			}
+			require.Equal(t, test.store.region, res.region)
+			require.Equal(t, test.store.endpoint, res.endpoint)
+			require.Equal(t, test.store.forcePathStyle, res.forcePathStyle)
+			require.Equal(t, test.store.provider, res.provider)
+			require.Equal(t, test.store.bucket, res.bucket)
+			require.Equal(t, test.store.path, res.path)
+		})
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	s3 "github.com/wealdtech/go-eth2-wallet-store-s3"
+	wtypes "github.com/wealdtech/go-eth2-wallet-types/v2"
+)
+
+func TestNewFromURL(t *testing.T) {
+	if os.Getenv("S3_CREDENTIALS_ID") == "" ||
+		os.Getenv("S3_CREDENTIALS_SECRET") == "" {
+		t.Skip("unable to access S3; skipping test")
+	}
+
+	location := fmt.Sprintf("s3://testnewfromurl-%d/a/b?region=us-west-1", time.Now().UnixNano())
+	store, err := s3.NewFromURL(location,
+		s3.WithCredentialsID(os.Getenv("S3_CREDENTIALS_ID")),
+		s3.WithCredentialsSecret(os.Getenv("S3_CREDENTIALS_SECRET")),
+	)
+	require.NoError(t, err)
+	require.Equal(t, location, store.(wtypes.StoreLocationProvider).Location())
+
+	_, err = s3.NewFromURL("s3://bucket?passphrase=secret")
+	require.EqualError(t, err, `unknown store URL parameter "passphrase"`)
+}
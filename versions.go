@@ -0,0 +1,351 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ObjectVersion is a historical version of an object in the store.
+// Versions are only retained if versioning is enabled on the store's bucket; see BucketProvisioning.
+type ObjectVersion struct {
+	// VersionID is the ID of the version, used to retrieve or restore it.
+	VersionID string
+	// LastModified is the time at which the version was written.
+	LastModified time.Time
+	// Size is the size of the version in bytes.
+	Size int64
+	// IsLatest is true if this is the current version of the object.
+	IsLatest bool
+	// DeleteMarker is true if this version marks the deletion of the object, in which case it has no data.
+	DeleteMarker bool
+}
+
+// ListAccountVersions lists the versions of an account, newest first.
+func (s *Store) ListAccountVersions(walletID uuid.UUID, accountID uuid.UUID) ([]*ObjectVersion, error) {
+	ctx, span := s.startSpan(context.Background(), "ListAccountVersions",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("account_id", accountID.String()),
+	)
+	versions, err := s.listVersions(ctx, s.accountPath(walletID, accountID))
+	endSpan(span, err)
+
+	return versions, err
+}
+
+// RetrieveAccountVersion retrieves the given version of an account.
+func (s *Store) RetrieveAccountVersion(walletID uuid.UUID, accountID uuid.UUID, versionID string) ([]byte, error) {
+	ctx, span := s.startSpan(context.Background(), "RetrieveAccountVersion",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("account_id", accountID.String()),
+		attribute.String("version_id", versionID),
+	)
+	_, data, err := s.retrieveVersion(ctx, s.accountPath(walletID, accountID), versionID)
+	endSpan(span, err)
+
+	return data, err
+}
+
+// RestoreAccountVersion makes the given version of an account its current version.
+// As with StoreAccount() the version is refused if another account in the wallet has its name.
+func (s *Store) RestoreAccountVersion(walletID uuid.UUID, accountID uuid.UUID, versionID string) error {
+	ctx, span := s.startSpan(context.Background(), "RestoreAccountVersion",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("account_id", accountID.String()),
+		attribute.String("version_id", versionID),
+	)
+	err := s.restoreAccountVersion(ctx, walletID, accountID, versionID)
+	endSpan(span, err)
+
+	return err
+}
+
+func (s *Store) restoreAccountVersion(ctx context.Context, walletID uuid.UUID, accountID uuid.UUID, versionID string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	key := s.accountPath(walletID, accountID)
+	stored, data, err := s.retrieveVersion(ctx, key, versionID)
+	if err != nil {
+		return err
+	}
+	pubKeys, err := accountPubKeys(data)
+	if err != nil {
+		return err
+	}
+
+	// The restored account may have a different name, so it is claimed as for a new account.
+	_, name, err := itemName(data)
+	if err != nil {
+		return err
+	}
+	claimed := false
+	if name != "" {
+		if claimed, err = s.claimAccountName(ctx, walletID, accountID, name); err != nil {
+			return err
+		}
+	}
+
+	if err := s.upload(ctx, key, stored, s.withObjectAttributes(ObjectKindAccount), s.withObjectLock()); err != nil {
+		if claimed {
+			s.releaseName(ctx, s.accountNamePath(walletID, name))
+		}
+
+		return errors.Wrap(err, "failed to restore version")
+	}
+
+	// The restored account may have a different public key, so it is added to the public key index.
+	return s.indexPubKeys(ctx, walletID, accountID, pubKeys)
+}
+
+// ListWalletVersions lists the versions of a wallet's header, newest first.
+func (s *Store) ListWalletVersions(walletID uuid.UUID) ([]*ObjectVersion, error) {
+	ctx, span := s.startSpan(context.Background(), "ListWalletVersions",
+		attribute.String("wallet_id", walletID.String()),
+	)
+	versions, err := s.listVersions(ctx, s.walletHeaderPath(walletID))
+	endSpan(span, err)
+
+	return versions, err
+}
+
+// RetrieveWalletVersion retrieves the given version of a wallet's header.
+func (s *Store) RetrieveWalletVersion(walletID uuid.UUID, versionID string) ([]byte, error) {
+	ctx, span := s.startSpan(context.Background(), "RetrieveWalletVersion",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("version_id", versionID),
+	)
+	_, data, err := s.retrieveVersion(ctx, s.walletHeaderPath(walletID), versionID)
+	endSpan(span, err)
+
+	return data, err
+}
+
+// RestoreWalletVersion makes the given version of a wallet's header its current version.
+// As with StoreWallet() the version is refused if another wallet has its name.
+func (s *Store) RestoreWalletVersion(walletID uuid.UUID, versionID string) error {
+	ctx, span := s.startSpan(context.Background(), "RestoreWalletVersion",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("version_id", versionID),
+	)
+	err := s.restoreWalletVersion(ctx, walletID, versionID)
+	endSpan(span, err)
+
+	return err
+}
+
+func (s *Store) restoreWalletVersion(ctx context.Context, walletID uuid.UUID, versionID string) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	key := s.walletHeaderPath(walletID)
+	stored, data, err := s.retrieveVersion(ctx, key, versionID)
+	if err != nil {
+		return err
+	}
+
+	// The restored wallet may have a different name, so it is claimed as for a new wallet.
+	_, name, err := itemName(data)
+	if err != nil {
+		return err
+	}
+	claimed := false
+	if name != "" {
+		if claimed, err = s.claimWalletName(ctx, walletID, name); err != nil {
+			return err
+		}
+	}
+
+	if err := s.upload(ctx, key, stored, s.withObjectAttributes(ObjectKindWallet), s.withObjectLock()); err != nil {
+		if claimed {
+			s.releaseName(ctx, s.walletNamePath(name))
+		}
+
+		return errors.Wrap(err, "failed to restore version")
+	}
+
+	return nil
+}
+
+// ListAccountsIndexVersions lists the versions of a wallet's account index, newest first.
+func (s *Store) ListAccountsIndexVersions(walletID uuid.UUID) ([]*ObjectVersion, error) {
+	ctx, span := s.startSpan(context.Background(), "ListAccountsIndexVersions",
+		attribute.String("wallet_id", walletID.String()),
+	)
+	versions, err := s.listVersions(ctx, s.walletIndexPath(walletID))
+	endSpan(span, err)
+
+	return versions, err
+}
+
+// RetrieveAccountsIndexVersion retrieves the given version of a wallet's account index.
+func (s *Store) RetrieveAccountsIndexVersion(walletID uuid.UUID, versionID string) ([]byte, error) {
+	ctx, span := s.startSpan(context.Background(), "RetrieveAccountsIndexVersion",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("version_id", versionID),
+	)
+	_, data, err := s.retrieveVersion(ctx, s.walletIndexPath(walletID), versionID)
+	endSpan(span, err)
+
+	return data, err
+}
+
+// RestoreAccountsIndexVersion makes the given version of a wallet's account index its current version.
+func (s *Store) RestoreAccountsIndexVersion(walletID uuid.UUID, versionID string) error {
+	ctx, span := s.startSpan(context.Background(), "RestoreAccountsIndexVersion",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("version_id", versionID),
+	)
+	err := s.restoreVersion(ctx, s.walletIndexPath(walletID), versionID, s.withObjectAttributes(ObjectKindIndex))
+	endSpan(span, err)
+
+	return err
+}
+
+// ListBatchVersions lists the versions of a wallet's batch, newest first.
+func (s *Store) ListBatchVersions(walletID uuid.UUID) ([]*ObjectVersion, error) {
+	ctx, span := s.startSpan(context.Background(), "ListBatchVersions",
+		attribute.String("wallet_id", walletID.String()),
+	)
+	versions, err := s.listVersions(ctx, s.walletBatchPath(walletID))
+	endSpan(span, err)
+
+	return versions, err
+}
+
+// RetrieveBatchVersion retrieves the given version of a wallet's batch.
+func (s *Store) RetrieveBatchVersion(walletID uuid.UUID, versionID string) ([]byte, error) {
+	ctx, span := s.startSpan(context.Background(), "RetrieveBatchVersion",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("version_id", versionID),
+	)
+	_, data, err := s.retrieveVersion(ctx, s.walletBatchPath(walletID), versionID)
+	endSpan(span, err)
+
+	return data, err
+}
+
+// RestoreBatchVersion makes the given version of a wallet's batch its current version.
+func (s *Store) RestoreBatchVersion(walletID uuid.UUID, versionID string) error {
+	ctx, span := s.startSpan(context.Background(), "RestoreBatchVersion",
+		attribute.String("wallet_id", walletID.String()),
+		attribute.String("version_id", versionID),
+	)
+	err := s.restoreVersion(ctx, s.walletBatchPath(walletID), versionID, s.withObjectAttributes(ObjectKindBatch))
+	endSpan(span, err)
+
+	return err
+}
+
+// listVersions lists the versions of the object with the given key, newest first.
+func (s *Store) listVersions(ctx context.Context, key string) ([]*ObjectVersion, error) {
+	ctx, span := s.startSpan(ctx, "ListObjectVersions",
+		attribute.String("aws.s3.bucket", s.bucket),
+		attribute.String("aws.s3.key", key),
+	)
+	ctx, cancel := s.operationContext(ctx)
+	defer cancel()
+
+	versions := make([]*ObjectVersion, 0)
+	err := s.client.ListObjectVersionsPagesWithContext(ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(key),
+	}, func(page *s3.ListObjectVersionsOutput, _ bool) bool {
+		// The prefix can match other keys, so only versions of the exact key are retained.
+		for _, version := range page.Versions {
+			if aws.StringValue(version.Key) == key {
+				versions = append(versions, &ObjectVersion{
+					VersionID:    aws.StringValue(version.VersionId),
+					LastModified: aws.TimeValue(version.LastModified),
+					Size:         aws.Int64Value(version.Size),
+					IsLatest:     aws.BoolValue(version.IsLatest),
+				})
+			}
+		}
+		for _, marker := range page.DeleteMarkers {
+			if aws.StringValue(marker.Key) == key {
+				versions = append(versions, &ObjectVersion{
+					VersionID:    aws.StringValue(marker.VersionId),
+					LastModified: aws.TimeValue(marker.LastModified),
+					IsLatest:     aws.BoolValue(marker.IsLatest),
+					DeleteMarker: true,
+				})
+			}
+		}
+
+		return true
+	}, s.requestOptions(span, key)...)
+	endSpan(span, err)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list versions")
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+
+	return versions, nil
+}
+
+// retrieveVersion retrieves the given version of the object with the given key.
+// It returns both the data as stored and the decrypted data.
+func (s *Store) retrieveVersion(ctx context.Context, key string, versionID string) ([]byte, []byte, error) {
+	if versionID == "" {
+		return nil, nil, errors.New("no version ID supplied")
+	}
+
+	stored, err := s.downloadVersion(ctx, key, versionID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to retrieve version")
+	}
+	// Do not decrypt empty index.
+	if len(stored) == 2 && strings.HasSuffix(key, "/index") {
+		return stored, stored, nil
+	}
+	data, err := s.decrypt(ctx, key, stored)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decrypt version")
+	}
+
+	return stored, data, nil
+}
+
+// restoreVersion makes the given version of the object with the given key its current version.
+// The version is checked to be readable by the store before it is restored.
+func (s *Store) restoreVersion(ctx context.Context, key string, versionID string, opts ...uploadOption) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	stored, _, err := s.retrieveVersion(ctx, key, versionID)
+	if err != nil {
+		return err
+	}
+	if err := s.upload(ctx, key, stored, opts...); err != nil {
+		return errors.Wrap(err, "failed to restore version")
+	}
+
+	return nil
+}
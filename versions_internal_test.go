@@ -0,0 +1,203 @@
+// Copyright 2023 Weald Technology Trading.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountVersions(t *testing.T) {
+	_, client := newMemoryS3(t)
+
+	for _, passphrase := range []string{"", "secret"} {
+		t.Run(fmt.Sprintf("Passphrase%q", passphrase), func(t *testing.T) {
+			opts := []Option{WithS3Client(client), WithBucket("bucket"), WithPath(fmt.Sprintf("store%s", passphrase))}
+			if passphrase != "" {
+				opts = append(opts, WithPassphrase([]byte(passphrase)))
+			}
+			store, err := New(opts...)
+			require.NoError(t, err)
+			s := store.(*Store)
+
+			walletID := uuid.New()
+			accountID := uuid.New()
+			require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+			original := []byte(fmt.Sprintf(`{"uuid":%q,"name":"account","key":"original"}`, accountID))
+			overwritten := []byte(fmt.Sprintf(`{"uuid":%q,"name":"account","key":"overwritten"}`, accountID))
+			require.NoError(t, s.StoreAccount(walletID, accountID, original))
+			require.NoError(t, s.StoreAccount(walletID, accountID, overwritten))
+
+			versions, err := s.ListAccountVersions(walletID, accountID)
+			require.NoError(t, err)
+			require.Len(t, versions, 2)
+			require.True(t, versions[0].IsLatest)
+			require.False(t, versions[1].IsLatest)
+			require.True(t, versions[0].LastModified.After(versions[1].LastModified))
+
+			data, err := s.RetrieveAccountVersion(walletID, accountID, versions[1].VersionID)
+			require.NoError(t, err)
+			require.Equal(t, original, data)
+
+			require.NoError(t, s.RestoreAccountVersion(walletID, accountID, versions[1].VersionID))
+			data, err = s.RetrieveAccount(walletID, accountID)
+			require.NoError(t, err)
+			require.Equal(t, original, data)
+
+			versions, err = s.ListAccountVersions(walletID, accountID)
+			require.NoError(t, err)
+			require.Len(t, versions, 3)
+
+			// Other objects in the wallet are not included.
+			versions, err = s.ListWalletVersions(walletID)
+			require.NoError(t, err)
+			require.Len(t, versions, 1)
+		})
+	}
+}
+
+func TestIndexVersions(t *testing.T) {
+	_, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithPassphrase([]byte("secret")))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreAccountsIndex(walletID, []byte("[]")))
+	require.NoError(t, s.StoreAccountsIndex(walletID, []byte(`[{"uuid":"c9958061-63d4-4a80-bcf3-25f3dda22340","name":"account"}]`)))
+
+	versions, err := s.ListAccountsIndexVersions(walletID)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+
+	// The empty index is stored unencrypted.
+	data, err := s.RetrieveAccountsIndexVersion(walletID, versions[1].VersionID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("[]"), data)
+
+	require.NoError(t, s.RestoreAccountsIndexVersion(walletID, versions[1].VersionID))
+	data, err = s.RetrieveAccountsIndex(walletID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("[]"), data)
+}
+
+func TestVersionsDeleted(t *testing.T) {
+	_, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+	require.NoError(t, s.StoreBatch(context.Background(), walletID, "wallet", []byte("batch")))
+	require.NoError(t, s.remove(context.Background(), s.walletBatchPath(walletID)))
+
+	versions, err := s.ListBatchVersions(walletID)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.True(t, versions[0].DeleteMarker)
+	require.True(t, versions[0].IsLatest)
+
+	_, err = s.RetrieveBatchVersion(walletID, versions[0].VersionID)
+	require.Error(t, err)
+	_, err = s.RetrieveBatchVersion(walletID, "")
+	require.EqualError(t, err, "no version ID supplied")
+
+	require.NoError(t, s.RestoreBatchVersion(walletID, versions[1].VersionID))
+	data, err := s.RetrieveBatch(context.Background(), walletID)
+	require.NoError(t, err)
+	require.Equal(t, []byte("batch"), data)
+}
+
+func TestVersionsReadOnly(t *testing.T) {
+	_, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"))
+	require.NoError(t, err)
+	walletID := uuid.New()
+	require.NoError(t, store.StoreWallet(walletID, "wallet", []byte(fmt.Sprintf(`{"uuid":%q,"name":"wallet"}`, walletID))))
+	require.NoError(t, store.(*Store).StoreBatch(context.Background(), walletID, "wallet", []byte("batch")))
+
+	store, err = Open(WithS3Client(client), WithBucket("bucket"), WithReadOnly(true))
+	require.NoError(t, err)
+	s := store.(*Store)
+	versions, err := s.ListBatchVersions(walletID)
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.ErrorIs(t, s.RestoreBatchVersion(walletID, versions[0].VersionID), ErrReadOnly)
+}
+
+func TestRestoreVersionNames(t *testing.T) {
+	m, client := newMemoryS3(t)
+	store, err := New(WithS3Client(client), WithBucket("bucket"), WithPassphrase([]byte("secret")))
+	require.NoError(t, err)
+	s := store.(*Store)
+
+	account := func(accountID uuid.UUID, name string) []byte {
+		return []byte(fmt.Sprintf(`{"uuid":%q,"name":%q}`, accountID, name))
+	}
+	wallet := func(walletID uuid.UUID, name string) []byte {
+		return []byte(fmt.Sprintf(`{"uuid":%q,"name":%q}`, walletID, name))
+	}
+
+	walletID := uuid.New()
+	require.NoError(t, s.StoreWallet(walletID, "first", wallet(walletID, "first")))
+	require.NoError(t, s.StoreWallet(walletID, "second", wallet(walletID, "second")))
+	walletVersions, err := s.ListWalletVersions(walletID)
+	require.NoError(t, err)
+	accountID := uuid.New()
+	require.NoError(t, s.StoreAccount(walletID, accountID, account(accountID, "first")))
+	require.NoError(t, s.StoreAccount(walletID, accountID, account(accountID, "second")))
+	accountVersions, err := s.ListAccountVersions(walletID, accountID)
+	require.NoError(t, err)
+
+	// Other items have taken the earlier names, so the earlier versions cannot be restored.
+	otherWalletID := uuid.New()
+	require.NoError(t, s.StoreWallet(otherWalletID, "first", wallet(otherWalletID, "first")))
+	otherAccountID := uuid.New()
+	require.NoError(t, s.StoreAccount(walletID, otherAccountID, account(otherAccountID, "first")))
+
+	require.ErrorIs(t, s.RestoreWalletVersion(walletID, walletVersions[1].VersionID), ErrDuplicateName)
+	data, err := s.RetrieveWalletByID(walletID)
+	require.NoError(t, err)
+	require.Equal(t, wallet(walletID, "second"), data)
+	require.ErrorIs(t, s.RestoreAccountVersion(walletID, accountID, accountVersions[1].VersionID), ErrDuplicateName)
+	data, err = s.RetrieveAccount(walletID, accountID)
+	require.NoError(t, err)
+	require.Equal(t, account(accountID, "second"), data)
+
+	// Once the names are free again the earlier versions are restored, and claim their names.
+	require.NoError(t, s.StoreWallet(otherWalletID, "other", wallet(otherWalletID, "other")))
+	require.NoError(t, s.StoreAccount(walletID, otherAccountID, account(otherAccountID, "other")))
+
+	require.NoError(t, s.RestoreWalletVersion(walletID, walletVersions[1].VersionID))
+	require.NoError(t, s.RestoreAccountVersion(walletID, accountID, accountVersions[1].VersionID))
+	marker := &nameMarker{}
+	data, err = s.decryptIfRequired(m.object("bucket", s.walletNamePath("first")))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, marker))
+	require.Equal(t, walletID, marker.ID)
+	data, err = s.decryptIfRequired(m.object("bucket", s.accountNamePath(walletID, "first")))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, marker))
+	require.Equal(t, accountID, marker.ID)
+
+	// The restored names are not available to other items.
+	require.ErrorIs(t, s.StoreWallet(otherWalletID, "first", wallet(otherWalletID, "first")), ErrDuplicateName)
+	require.ErrorIs(t, s.StoreAccount(walletID, otherAccountID, account(otherAccountID, "first")), ErrDuplicateName)
+}
@@ -14,35 +14,63 @@
 package s3
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"sort"
 	"strings"
 	"sync"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // StoreWallet stores wallet-level data.  It will fail if it cannot store the data.
-// Note that this will overwrite any existing data; it is up to higher-level functions to check for the presence of a wallet with
-// the wallet name and handle clashes accordingly.
-func (s *Store) StoreWallet(id uuid.UUID, _ string, data []byte) error {
+// Note that this will overwrite any existing data for a wallet with the same ID.  It will not, however, allow multiple
+// wallets with the same name to co-exist in the store, returning a *DuplicateNameError if another wallet has the name.
+func (s *Store) StoreWallet(id uuid.UUID, name string, data []byte) error {
+	ctx, span := s.startSpan(context.Background(), "StoreWallet",
+		attribute.String("wallet_id", id.String()),
+	)
+	err := s.storeWallet(ctx, id, name, data)
+	endSpan(span, err)
+
+	return err
+}
+
+func (s *Store) storeWallet(ctx context.Context, id uuid.UUID, name string, data []byte) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+
+	// Ensure no other wallet has this name.
+	_, dataName, err := itemName(data)
+	if err != nil {
+		return err
+	}
+	switch {
+	case name == "":
+		name = dataName
+	case dataName != "" && dataName != name:
+		return errors.New("wallet name does not match data")
+	}
+	claimed := false
+	if name != "" {
+		if claimed, err = s.claimWalletName(ctx, id, name); err != nil {
+			return err
+		}
+	}
+
 	path := s.walletHeaderPath(id)
-	var err error
 	data, err = s.encryptIfRequired(data)
 	if err != nil {
 		return errors.Wrap(err, "failed to encrypt wallet")
 	}
-	uploader := s3manager.NewUploader(s.session)
-	_, err = uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(path),
-		Body:   bytes.NewReader(data),
-	})
-	if err != nil {
+	if err := s.upload(ctx, path, data, s.withObjectAttributes(ObjectKindWallet), s.withObjectLock()); err != nil {
+		if claimed {
+			s.releaseName(ctx, s.walletNamePath(name))
+		}
+
 		return errors.Wrap(err, "failed to store wallet")
 	}
 
@@ -50,23 +78,53 @@ func (s *Store) StoreWallet(id uuid.UUID, _ string, data []byte) error {
 }
 
 // RetrieveWallet retrieves wallet-level data.  It will fail if it cannot retrieve the data.
+// If more than one wallet has the name, as can happen in stores written by earlier versions of this module, it
+// returns a *DuplicateWalletsError.
 func (s *Store) RetrieveWallet(walletName string) ([]byte, error) {
-	for data := range s.RetrieveWallets() {
-		info := &struct {
-			Name string `json:"name"`
-		}{}
-		err := json.Unmarshal(data, info)
-		if err == nil && info.Name == walletName {
-			return data, nil
+	ctx, span := s.startSpan(context.Background(), "RetrieveWallet")
+	data, err := s.retrieveWallet(ctx, walletName)
+	endSpan(span, err)
+
+	return data, err
+}
+
+func (s *Store) retrieveWallet(ctx context.Context, walletName string) ([]byte, error) {
+	// All wallets are checked, so that duplicates are found regardless of the order in which wallets arrive.
+	var res []byte
+	ids := make([]uuid.UUID, 0, 1)
+	for data := range s.retrieveWallets(ctx) {
+		id, name, err := itemName(data)
+		if err == nil && name == walletName {
+			res = data
+			ids = append(ids, id)
 		}
 	}
 
-	return nil, errors.New("wallet not found")
+	switch len(ids) {
+	case 0:
+		return nil, errors.New("wallet not found")
+	case 1:
+		return res, nil
+	default:
+		sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+
+		return nil, &DuplicateWalletsError{Name: walletName, IDs: ids}
+	}
 }
 
 // RetrieveWalletByID retrieves wallet-level data.  It will fail if it cannot retrieve the data.
 func (s *Store) RetrieveWalletByID(walletID uuid.UUID) ([]byte, error) {
-	for data := range s.RetrieveWallets() {
+	ctx, span := s.startSpan(context.Background(), "RetrieveWalletByID",
+		attribute.String("wallet_id", walletID.String()),
+	)
+	data, err := s.retrieveWalletByID(ctx, walletID)
+	endSpan(span, err)
+
+	return data, err
+}
+
+func (s *Store) retrieveWalletByID(ctx context.Context, walletID uuid.UUID) ([]byte, error) {
+	for data := range s.retrieveWallets(ctx) {
 		info := &struct {
 			ID uuid.UUID `json:"uuid"`
 		}{}
@@ -81,35 +139,31 @@ func (s *Store) RetrieveWalletByID(walletID uuid.UUID) ([]byte, error) {
 
 // RetrieveWallets retrieves wallet-level data for all wallets.
 func (s *Store) RetrieveWallets() <-chan []byte {
+	ctx, span := s.startSpan(context.Background(), "RetrieveWallets")
 	ch := make(chan []byte, elementCapacity)
 	go func() {
-		conn := s3.New(s.session)
-
-		contents := make([]*s3.Object, 0, elementCapacity)
-		var continuationToken *string
-		for finished := false; !finished; {
-			resp, err := conn.ListObjectsV2(&s3.ListObjectsV2Input{
-				Bucket:            aws.String(s.bucket),
-				Prefix:            aws.String(s.path),
-				ContinuationToken: continuationToken,
-			})
-			if err != nil {
-				close(ch)
-				return
-			}
-			contents = append(contents, resp.Contents...)
-			if resp.IsTruncated != nil && (*resp.IsTruncated) {
-				continuationToken = resp.NextContinuationToken
-			} else {
-				finished = true
-			}
+		for data := range s.retrieveWallets(ctx) {
+			ch <- data
+		}
+		span.End()
+		close(ch)
+	}()
+
+	return ch
+}
+
+func (s *Store) retrieveWallets(ctx context.Context) <-chan []byte {
+	ch := make(chan []byte, elementCapacity)
+	go func() {
+		contents, err := s.listObjects(ctx, s.path)
+		if err != nil {
+			s.log.Error().Err(err).Msg("Failed to list objects")
+			close(ch)
+			return
 		}
 
-		// Download items concurrently (up to concurrency limit).
+		// Download items concurrently.
 		wg := sync.WaitGroup{}
-		downloader := s3manager.NewDownloader(s.session, func(d *s3manager.Downloader) {
-			d.Concurrency = downloadConcurrency
-		})
 		for _, content := range contents {
 			if strings.HasSuffix(*content.Key, "/") {
 				// Directory.
@@ -117,27 +171,24 @@ func (s *Store) RetrieveWallets() <-chan []byte {
 			}
 			// This is only a wallet if the last two components of the path are the same.
 			components := strings.Split(*content.Key, "/")
-			if components[len(components)-1] != components[len(components)-2] {
+			if len(components) < 2 || components[len(components)-1] != components[len(components)-2] {
 				continue
 			}
 			wg.Add(1)
-			go func(content *s3.Object) {
+			go func(key string) {
 				defer wg.Done()
-				buf := aws.NewWriteAtBuffer(make([]byte, 0, itemCapacity))
-				_, err := downloader.Download(buf,
-					&s3.GetObjectInput{
-						Bucket: aws.String(s.bucket),
-						Key:    aws.String(*content.Key),
-					})
+				data, err := s.downloadLimited(ctx, key)
 				if err != nil {
+					s.log.Warn().Str("key", key).Str("code", errorCode(err)).Err(err).Msg("Failed to download object; skipping")
 					return
 				}
-				data, err := s.decryptIfRequired(buf.Bytes())
+				data, err = s.decrypt(ctx, key, data)
 				if err != nil {
+					s.log.Warn().Str("key", key).Err(err).Msg("Failed to decrypt object; skipping")
 					return
 				}
 				ch <- data
-			}(content)
+			}(*content.Key)
 		}
 		wg.Wait()
 		close(ch)